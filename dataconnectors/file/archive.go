@@ -0,0 +1,197 @@
+package file
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// Separates the archive path from the member pattern, e.g. bundle.zip#daily/*.csv
+	archiveMemberSeparator string = "#"
+)
+
+var (
+	zipMagic = []byte("PK\x03\x04")
+)
+
+type archiveMember struct {
+	name    string
+	modTime time.Time
+	data    []byte
+}
+
+// Splits a path of the form <archive>#<member pattern> into its parts. '#' only separates a member pattern
+// after an archive name such as bundle.zip or bundle.tar.gz, so paths like data#2021.csv are read as is,
+// and a file named by the whole path is read rather than an archive member.
+func splitArchivePath(fullPath string, appDir string) (string, string, error) {
+	sepIndex := strings.LastIndex(fullPath, archiveMemberSeparator)
+	if sepIndex == -1 {
+		return fullPath, "", nil
+	}
+
+	archivePath := fullPath[:sepIndex]
+	memberPattern := fullPath[sepIndex+1:]
+
+	if !isArchiveName(archivePath) {
+		return fullPath, "", nil
+	}
+
+	resolvedPath := fullPath
+	if !filepath.IsAbs(resolvedPath) {
+		resolvedPath = filepath.Join(appDir, resolvedPath)
+	}
+	if info, err := os.Stat(resolvedPath); err == nil && info.Mode().IsRegular() {
+		return fullPath, "", nil
+	}
+
+	if memberPattern == "" {
+		return "", "", fmt.Errorf("path '%s' is missing an archive member pattern", fullPath)
+	}
+
+	if _, err := path.Match(memberPattern, ""); err != nil {
+		return "", "", fmt.Errorf("invalid archive member pattern '%s': %w", memberPattern, err)
+	}
+
+	return archivePath, memberPattern, nil
+}
+
+// Whether name has a zip or tar extension, including compressed tars such as .tar.gz and .tgz
+func isArchiveName(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	switch ext {
+	case ".zip", ".tar", ".tgz":
+		return true
+	}
+
+	if _, ok := compressionExtensions[ext]; ok {
+		return strings.EqualFold(filepath.Ext(strings.TrimSuffix(name, filepath.Ext(name))), ".tar")
+	}
+
+	return false
+}
+
+func isZipArchive(archivePath string, reader *bufio.Reader) bool {
+	if strings.EqualFold(filepath.Ext(archivePath), ".zip") {
+		return true
+	}
+
+	header, err := reader.Peek(len(zipMagic))
+	return err == nil && bytes.Equal(header, zipMagic)
+}
+
+// Reads all regular members of a zip archive matching pattern
+func readZipMembers(archivePath string, pattern string) ([]*archiveMember, error) {
+	zipReader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer zipReader.Close()
+
+	var members []*archiveMember
+	for _, zipFile := range zipReader.File {
+		if zipFile.FileInfo().IsDir() {
+			continue
+		}
+
+		if matched, _ := path.Match(pattern, zipFile.Name); !matched {
+			continue
+		}
+
+		memberReader, err := zipFile.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open archive member '%s': %w", zipFile.Name, err)
+		}
+
+		data, err := io.ReadAll(memberReader)
+		memberReader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive member '%s': %w", zipFile.Name, err)
+		}
+
+		members = append(members, &archiveMember{
+			name:    zipFile.Name,
+			modTime: zipFile.Modified,
+			data:    data,
+		})
+	}
+
+	return members, nil
+}
+
+// Reads all regular members of an (already decompressed) tar stream matching pattern
+func readTarMembers(reader io.Reader, pattern string) ([]*archiveMember, error) {
+	tarReader := tar.NewReader(reader)
+
+	var members []*archiveMember
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := strings.TrimPrefix(header.Name, "./")
+		if matched, _ := path.Match(pattern, name); !matched {
+			continue
+		}
+
+		data, err := io.ReadAll(tarReader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive member '%s': %w", name, err)
+		}
+
+		members = append(members, &archiveMember{
+			name:    name,
+			modTime: header.ModTime,
+			data:    data,
+		})
+	}
+
+	return members, nil
+}
+
+// Reads the members of the zip or tar archive at archivePath that match pattern.
+// Tar archives may be compressed with any supported compression.
+func readArchiveMembers(archivePath string, pattern string, compression string) ([]*archiveMember, string, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+
+	bufReader := bufio.NewReader(file)
+
+	if isZipArchive(archivePath, bufReader) {
+		members, err := readZipMembers(archivePath, pattern)
+		return members, compressionNone, err
+	}
+
+	if compression == compressionAuto {
+		compression = detectCompression(archivePath, bufReader)
+	}
+
+	reader, err := newDecompressReader(compression, bufReader)
+	if err != nil {
+		return nil, "", err
+	}
+	defer reader.Close()
+
+	members, err := readTarMembers(reader, pattern)
+	return members, compression, err
+}
@@ -0,0 +1,118 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+const (
+	compressionAuto  string = "auto"
+	compressionNone  string = "none"
+	compressionGzip  string = "gzip"
+	compressionZstd  string = "zstd"
+	compressionBzip2 string = "bzip2"
+	compressionXz    string = "xz"
+)
+
+var (
+	compressionExtensions = map[string]string{
+		".gz":   compressionGzip,
+		".gzip": compressionGzip,
+		".tgz":  compressionGzip,
+		".zst":  compressionZstd,
+		".zstd": compressionZstd,
+		".bz2":  compressionBzip2,
+		".xz":   compressionXz,
+	}
+
+	compressionMagic = []struct {
+		compression string
+		magic       []byte
+	}{
+		{compressionGzip, []byte{0x1f, 0x8b}},
+		{compressionZstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+		{compressionBzip2, []byte("BZh")},
+		{compressionXz, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	}
+)
+
+func isValidCompression(compression string) bool {
+	switch compression {
+	case compressionAuto, compressionNone, compressionGzip, compressionZstd, compressionBzip2, compressionXz:
+		return true
+	}
+	return false
+}
+
+// Detects the compression of a file by its extension, falling back to the leading magic bytes
+func detectCompression(path string, reader *bufio.Reader) string {
+	if compression, ok := compressionExtensions[strings.ToLower(filepath.Ext(path))]; ok {
+		return compression
+	}
+
+	for _, m := range compressionMagic {
+		header, err := reader.Peek(len(m.magic))
+		if err == nil && bytes.Equal(header, m.magic) {
+			return m.compression
+		}
+	}
+
+	return compressionNone
+}
+
+// Reads all of reader, decompressing it with the given or detected compression
+func readDecompressed(path string, compression string, reader io.Reader) ([]byte, string, error) {
+	bufReader := bufio.NewReader(reader)
+
+	if compression == compressionAuto {
+		compression = detectCompression(path, bufReader)
+	}
+
+	decompressReader, err := newDecompressReader(compression, bufReader)
+	if err != nil {
+		return nil, "", err
+	}
+	defer decompressReader.Close()
+
+	data, err := io.ReadAll(decompressReader)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, compression, nil
+}
+
+// Wraps reader with a streaming decompressor for the given compression
+func newDecompressReader(compression string, reader io.Reader) (io.ReadCloser, error) {
+	switch compression {
+	case compressionNone:
+		return io.NopCloser(reader), nil
+	case compressionGzip:
+		return gzip.NewReader(reader)
+	case compressionZstd:
+		decoder, err := zstd.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	case compressionBzip2:
+		return io.NopCloser(bzip2.NewReader(reader)), nil
+	case compressionXz:
+		xzReader, err := xz.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xzReader), nil
+	}
+
+	return nil, fmt.Errorf("unknown compression '%s'", compression)
+}
@@ -4,8 +4,9 @@ import (
 	"context"
 	"fmt"
 	"io/fs"
-	"io/ioutil"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"sync"
@@ -21,13 +22,27 @@ const (
 )
 
 type FileConnector struct {
-	path         string
-	noWatch      bool
-	readHandlers []*func(data []byte, metadata map[string]string) ([]byte, error)
-
-	dataMutex sync.RWMutex
-	fileInfo  fs.FileInfo
-	data      []byte
+	path          string
+	memberPattern string
+	noWatch       bool
+	compression   string
+	appDir        string
+	allowedRoots  []string
+	allowedHosts  []string
+	strictPaths   bool
+	readHandlers  []*func(data []byte, metadata map[string]string) ([]byte, error)
+
+	url          *url.URL
+	pollInterval time.Duration
+	httpClient   *http.Client
+	etag         string
+	lastModified string
+
+	dataMutex       sync.RWMutex
+	fileInfo        fs.FileInfo
+	data            []byte
+	members         []*archiveMember
+	dataCompression string
 }
 
 func NewFileConnector() *FileConnector {
@@ -37,15 +52,43 @@ func NewFileConnector() *FileConnector {
 func (c *FileConnector) Init(epoch time.Time, period time.Duration, interval time.Duration, params map[string]string) error {
 	c.dataMutex = sync.RWMutex{}
 
-	path := params["path"]
+	c.compression = compressionAuto
+	if compression, ok := params["compression"]; ok {
+		if !isValidCompression(compression) {
+			return fmt.Errorf("invalid compression '%s'", compression)
+		}
+		c.compression = compression
+	}
+
 	appDir := params["appDirectory"]
+
+	c.appDir = appDir
+	c.allowedRoots = parseAllowedList(params["allowed_roots"])
+	c.allowedHosts = parseAllowedList(params["allowed_hosts"])
+	c.strictPaths = params["strict_paths"] == "true"
+
+	if isURL(params["path"]) {
+		c.path = params["path"]
+		return c.initURL(params)
+	}
+
+	path, memberPattern, err := splitArchivePath(params["path"], appDir)
+	if err != nil {
+		return err
+	}
 	if !filepath.IsAbs(path) {
 		path = filepath.Join(appDir, path)
 	}
 
 	c.path = path
+	c.memberPattern = memberPattern
 	c.noWatch = params["watch"] != "true"
 
+	err = c.checkPath(c.path)
+	if err != nil {
+		return err
+	}
+
 	newFileInfo, err := os.Stat(c.path)
 	if err == nil {
 		_, err := c.loadFileData(newFileInfo)
@@ -75,13 +118,44 @@ func (c *FileConnector) loadFileData(newFileInfo fs.FileInfo) ([]byte, error) {
 
 	loadStartTime := time.Now()
 
-	fileData, err := ioutil.ReadFile(c.path)
+	// Re-check in case a symlink was changed since Init
+	err := c.checkPath(c.path)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.memberPattern != "" {
+		members, compression, err := readArchiveMembers(c.path, c.memberPattern, c.compression)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive '%s': %w", c.path, err)
+		}
+
+		c.data = nil
+		c.members = members
+		c.fileInfo = newFileInfo
+		c.dataCompression = compression
+
+		duration := time.Since(loadStartTime)
+
+		log.Println(aurora.Green(fmt.Sprintf("loaded %d members matching '%s' from archive '%s' in %.2f seconds ...", len(members), c.memberPattern, filepath.Base(c.path), duration.Seconds())))
+
+		return nil, nil
+	}
+
+	file, err := os.Open(c.path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open file '%s': %w", c.path, err)
 	}
+	defer file.Close()
+
+	fileData, compression, err := readDecompressed(c.path, c.compression, file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file '%s': %w", c.path, err)
+	}
 
 	c.data = fileData
 	c.fileInfo = newFileInfo
+	c.dataCompression = compression
 
 	duration := time.Since(loadStartTime)
 
@@ -107,7 +181,7 @@ func (c *FileConnector) watchPath() {
 		for {
 			select {
 			case event := <-watcher.Events:
-				err := c.processWatchNotifyEvent(event, c.path)
+				err := c.processWatchNotifyEvent(event)
 				if err != nil {
 					log.Println(fmt.Errorf("error processing '%s' event %s: %w", c.path, event, err))
 				}
@@ -118,41 +192,69 @@ func (c *FileConnector) watchPath() {
 	}()
 }
 
-func (c *FileConnector) processWatchNotifyEvent(event fsnotify.Event, path string) error {
+func (c *FileConnector) processWatchNotifyEvent(event fsnotify.Event) error {
 	switch event.Op {
 	case fsnotify.Create:
 		fallthrough
 	case fsnotify.Write:
-		file := filepath.Join(path, event.Name)
+		file := event.Name
 		newFileInfo, err := os.Stat(file)
 		if err != nil {
 			return fmt.Errorf("failed to open file '%s': %w", file, err)
 		}
+
 		c.dataMutex.Lock()
-		defer c.dataMutex.Unlock()
-		if c.fileInfo == nil || newFileInfo.ModTime().After(c.fileInfo.ModTime()) {
-			// Only load file if it's changed since last read
+		// Only load file if it's changed since last read
+		changed := c.fileInfo == nil || newFileInfo.ModTime().After(c.fileInfo.ModTime())
+		if changed {
 			_, err = c.loadFileData(newFileInfo)
-			if err != nil {
-				return err
-			}
-			err = c.sendData()
-			if err != nil {
-				return err
-			}
+		}
+		c.dataMutex.Unlock()
+
+		if err != nil {
+			return err
+		}
+
+		if changed {
+			return c.sendData()
 		}
 	case fsnotify.Remove:
 		c.dataMutex.Lock()
 		defer c.dataMutex.Unlock()
 		c.fileInfo = nil
 		c.data = nil
+		c.members = nil
 	}
 
 	return nil
 }
 
 func (c *FileConnector) sendData() error {
-	if len(c.readHandlers) == 0 || c.fileInfo == nil || c.data == nil {
+	c.dataMutex.RLock()
+	defer c.dataMutex.RUnlock()
+
+	if len(c.readHandlers) == 0 || c.fileInfo == nil {
+		// Nothing to read
+		return nil
+	}
+
+	if c.memberPattern != "" {
+		// Each archive member is delivered as its own payload
+		for _, member := range c.members {
+			metadata := map[string]string{}
+			metadata["member"] = member.name
+			metadata["mod_time"] = member.modTime.Format(time.RFC3339Nano)
+			metadata["size"] = fmt.Sprintf("%d", len(member.data))
+
+			err := c.sendPayload(member.data, metadata)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if c.data == nil {
 		// Nothing to read
 		return nil
 	}
@@ -160,20 +262,21 @@ func (c *FileConnector) sendData() error {
 	metadata := map[string]string{}
 	metadata["mod_time"] = c.fileInfo.ModTime().Format(time.RFC3339Nano)
 	metadata["size"] = fmt.Sprintf("%d", c.fileInfo.Size())
+	if c.dataCompression != compressionNone {
+		metadata["compression"] = c.dataCompression
+		metadata["decompressed_size"] = fmt.Sprintf("%d", len(c.data))
+	}
 
-	errGroup, _ := errgroup.WithContext(context.Background())
-
-	c.dataMutex.RLock()
-	defer c.dataMutex.RUnlock()
+	return c.sendPayload(c.data, metadata)
+}
 
-	if len(c.readHandlers) == 0 {
-		return nil
-	}
+func (c *FileConnector) sendPayload(data []byte, metadata map[string]string) error {
+	errGroup, _ := errgroup.WithContext(context.Background())
 
 	for _, handler := range c.readHandlers {
 		readHandler := *handler
 		errGroup.Go(func() error {
-			_, err := readHandler(c.data, metadata)
+			_, err := readHandler(data, metadata)
 			return err
 		})
 	}
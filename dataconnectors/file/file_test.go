@@ -2,7 +2,12 @@ package file_test
 
 import (
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
@@ -27,6 +32,161 @@ func TestFileConnector(t *testing.T) {
 	}
 }
 
+func TestFileConnectorCompressed(t *testing.T) {
+	expectedData, err := os.ReadFile("../../test/assets/data/csv/trader_input.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filesToTest := map[string]string{
+		"trader_input.csv.gz":  "gzip",
+		"trader_input.csv.bz2": "bzip2",
+		"trader_input.csv.xz":  "xz",
+		"trader_input.csv.zst": "zstd",
+		"trader_input.dat":     "gzip",
+	}
+	for fileToTest, compression := range filesToTest {
+		filePath := filepath.Join("../../test/assets/data/compressed", fileToTest)
+
+		params := make(map[string]string)
+		params["path"] = filePath
+		params["watch"] = "false"
+
+		t.Run(fmt.Sprintf("Read() - %s", fileToTest), testReadCompressedFunc(params, compression, expectedData))
+	}
+}
+
+func TestFileConnectorArchive(t *testing.T) {
+	archivesToTest := []string{"bundle.zip", "bundle.tar.gz"}
+	for _, archiveToTest := range archivesToTest {
+		filePath := filepath.Join("../../test/assets/data/archive", archiveToTest)
+
+		params := make(map[string]string)
+		params["path"] = filePath + "#daily/*.csv"
+		params["watch"] = "false"
+
+		t.Run(fmt.Sprintf("Read() - %s", archiveToTest), testReadArchiveFunc(params))
+	}
+
+	t.Run("Read() with watch - bundle.zip", testReadArchiveWatchFunc())
+
+	// '#' only separates an archive member after an archive name, or when no file has the whole name
+	for _, fileName := range []string{"data#2021.csv", "export.zip#1.csv"} {
+		t.Run(fmt.Sprintf("Read() - %s", fileName), testReadHashFileFunc(fileName))
+	}
+}
+
+func testReadHashFileFunc(fileName string) func(*testing.T) {
+	return func(t *testing.T) {
+		appDir := t.TempDir()
+		fileData := []byte("time,value\n1633046400,1\n")
+		if err := os.WriteFile(filepath.Join(appDir, fileName), fileData, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		c := file.NewFileConnector()
+
+		var readData []byte
+		err := c.Read(func(data []byte, metadata map[string]string) ([]byte, error) {
+			readData = data
+			return nil, nil
+		})
+		assert.NoError(t, err)
+
+		var epoch time.Time
+		var period time.Duration
+		var interval time.Duration
+
+		err = c.Init(epoch, period, interval, map[string]string{
+			"path":         fileName,
+			"appDirectory": appDir,
+			"watch":        "false",
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, fileData, readData)
+	}
+}
+
+func TestFileConnectorURL(t *testing.T) {
+	t.Run("Read() with poll - trader_input.csv", testReadURLFunc())
+}
+
+func TestFileConnectorSandbox(t *testing.T) {
+	rootDir := t.TempDir()
+	appDir := filepath.Join(rootDir, "app")
+	outsideDir := filepath.Join(rootDir, "outside")
+
+	for _, dir := range []string{appDir, outsideDir} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, filePath := range []string{filepath.Join(appDir, "data.csv"), filepath.Join(outsideDir, "secret.csv")} {
+		if err := os.WriteFile(filePath, []byte("time,value\n1633046400,1\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.Symlink(filepath.Join(outsideDir, "secret.csv"), filepath.Join(appDir, "link.csv")); err != nil {
+		t.Fatal(err)
+	}
+
+	internalServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("time,value\n1633046400,1\n"))
+	}))
+	defer internalServer.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect.csv" {
+			http.Redirect(w, r, internalServer.URL+"/secret.csv", http.StatusFound)
+			return
+		}
+		_, _ = w.Write([]byte("time,value\n1633046400,1\n"))
+	}))
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		params    map[string]string
+		sandbox   *file.PathSandbox
+		expectErr bool
+	}{
+		{"strict - inside appDirectory", map[string]string{"path": "data.csv", "strict_paths": "true"}, nil, false},
+		{"strict - relative traversal", map[string]string{"path": "../outside/secret.csv", "strict_paths": "true"}, nil, true},
+		{"strict - absolute path", map[string]string{"path": filepath.Join(outsideDir, "secret.csv"), "strict_paths": "true"}, nil, true},
+		{"strict - symlink escape", map[string]string{"path": "link.csv", "strict_paths": "true"}, nil, true},
+		{"allowed_roots - allowed", map[string]string{"path": "../outside/secret.csv", "allowed_roots": outsideDir}, nil, false},
+		{"allowed_roots - rejected", map[string]string{"path": "../outside/secret.csv", "allowed_roots": appDir}, nil, true},
+		{"host sandbox - strict", map[string]string{"path": "../outside/secret.csv"}, &file.PathSandbox{Strict: true}, true},
+		{"host sandbox - allowed roots", map[string]string{"path": "link.csv"}, &file.PathSandbox{AllowedRoots: []string{appDir}}, true},
+		{"host sandbox - params cannot relax", map[string]string{"path": "../outside/secret.csv", "allowed_roots": outsideDir}, &file.PathSandbox{AllowedRoots: []string{appDir}}, true},
+		{"url - not sandboxed", map[string]string{"path": server.URL + "/data.csv"}, nil, false},
+		{"url - strict without allowed_hosts", map[string]string{"path": server.URL + "/data.csv", "strict_paths": "true"}, nil, true},
+		{"url - allowed_roots without allowed_hosts", map[string]string{"path": server.URL + "/data.csv", "allowed_roots": appDir}, nil, true},
+		{"url - strict with allowed_hosts", map[string]string{"path": server.URL + "/data.csv", "strict_paths": "true", "allowed_hosts": serverURL.Hostname()}, nil, false},
+		{"url - allowed_hosts rejected", map[string]string{"path": server.URL + "/data.csv", "allowed_hosts": "data.example.com"}, nil, true},
+		{"url - redirect to disallowed host", map[string]string{"path": server.URL + "/redirect.csv", "allowed_hosts": serverURL.Host}, nil, true},
+		{"host sandbox - url", map[string]string{"path": server.URL + "/data.csv"}, &file.PathSandbox{Strict: true}, true},
+		{"host sandbox - url allowed host", map[string]string{"path": server.URL + "/data.csv"}, &file.PathSandbox{Strict: true, AllowedHosts: []string{serverURL.Host}}, false},
+		{"host sandbox - url params cannot relax", map[string]string{"path": server.URL + "/data.csv", "allowed_hosts": serverURL.Host}, &file.PathSandbox{AllowedHosts: []string{"data.example.com"}}, true},
+	}
+
+	for _, test := range tests {
+		params := test.params
+		params["appDirectory"] = appDir
+		params["watch"] = "false"
+		params["poll_interval"] = "0"
+
+		t.Run(fmt.Sprintf("Init() - %s", test.name), testInitSandboxFunc(params, test.sandbox, test.expectErr))
+	}
+}
+
 func testInitFunc(params map[string]string) func(*testing.T) {
 	c := file.NewFileConnector()
 
@@ -74,3 +234,229 @@ func testReadFunc(params map[string]string) func(*testing.T) {
 		snapshotter.SnapshotT(t, string(readData))
 	}
 }
+
+func testReadCompressedFunc(params map[string]string, compression string, expectedData []byte) func(*testing.T) {
+	c := file.NewFileConnector()
+
+	return func(t *testing.T) {
+		var readData []byte
+		var readMetadata map[string]string
+
+		readChan := make(chan bool, 1)
+
+		err := c.Read(func(data []byte, metadata map[string]string) ([]byte, error) {
+			readData = data
+			readMetadata = metadata
+			readChan <- true
+			return nil, nil
+		})
+		assert.NoError(t, err)
+
+		var epoch time.Time
+		var period time.Duration
+		var interval time.Duration
+
+		err = c.Init(epoch, period, interval, params)
+		assert.NoError(t, err)
+
+		<-readChan
+
+		fileInfo, err := os.Stat(params["path"])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, string(expectedData), string(readData))
+		assert.Equal(t, compression, readMetadata["compression"])
+		assert.Equal(t, fmt.Sprintf("%d", fileInfo.Size()), readMetadata["size"])
+		assert.Equal(t, fmt.Sprintf("%d", len(expectedData)), readMetadata["decompressed_size"])
+	}
+}
+
+func testReadArchiveFunc(params map[string]string) func(*testing.T) {
+	c := file.NewFileConnector()
+
+	return func(t *testing.T) {
+		readMembers := make(map[string][]byte)
+		var readMetadata []map[string]string
+
+		err := c.Read(func(data []byte, metadata map[string]string) ([]byte, error) {
+			readMembers[metadata["member"]] = data
+			readMetadata = append(readMetadata, metadata)
+			return nil, nil
+		})
+		assert.NoError(t, err)
+
+		var epoch time.Time
+		var period time.Duration
+		var interval time.Duration
+
+		err = c.Init(epoch, period, interval, params)
+		assert.NoError(t, err)
+
+		expectedData, err := os.ReadFile("../../test/assets/data/csv/trader_input.csv")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Len(t, readMembers, 2)
+		assert.Equal(t, string(expectedData), string(readMembers["daily/trader_input.csv"]))
+		assert.Contains(t, readMembers, "daily/custom_time.csv")
+		assert.NotContains(t, readMembers, "other/readme.txt")
+
+		for _, metadata := range readMetadata {
+			assert.Equal(t, fmt.Sprintf("%d", len(readMembers[metadata["member"]])), metadata["size"])
+			_, err = time.Parse(time.RFC3339Nano, metadata["mod_time"])
+			assert.NoError(t, err)
+		}
+	}
+}
+
+func testReadArchiveWatchFunc() func(*testing.T) {
+	c := file.NewFileConnector()
+
+	return func(t *testing.T) {
+		archiveData, err := os.ReadFile("../../test/assets/data/archive/bundle.zip")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		archivePath := filepath.Join(t.TempDir(), "bundle.zip")
+		err = os.WriteFile(archivePath, archiveData, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		readChan := make(chan string, 10)
+
+		err = c.Read(func(data []byte, metadata map[string]string) ([]byte, error) {
+			readChan <- metadata["member"]
+			return nil, nil
+		})
+		assert.NoError(t, err)
+
+		params := make(map[string]string)
+		params["path"] = archivePath + "#daily/trader_input.csv"
+		params["watch"] = "true"
+
+		var epoch time.Time
+		var period time.Duration
+		var interval time.Duration
+
+		err = c.Init(epoch, period, interval, params)
+		assert.NoError(t, err)
+
+		assert.Equal(t, "daily/trader_input.csv", <-readChan)
+
+		// Give the watcher time to start before touching the archive
+		time.Sleep(100 * time.Millisecond)
+
+		err = os.WriteFile(archivePath, archiveData, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case member := <-readChan:
+			assert.Equal(t, "daily/trader_input.csv", member)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for archive change to be read")
+		}
+	}
+}
+
+func testReadURLFunc() func(*testing.T) {
+	c := file.NewFileConnector()
+
+	return func(t *testing.T) {
+		lastModified := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+		content := []byte("time,value\n1633046400,1\n")
+		etag := `"v1"`
+
+		contentMutex := sync.Mutex{}
+		notModifiedCount := 0
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			contentMutex.Lock()
+			defer contentMutex.Unlock()
+
+			if r.Header.Get("If-None-Match") == etag {
+				notModifiedCount++
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+
+			w.Header().Set("ETag", etag)
+			w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+			_, _ = w.Write(content)
+		}))
+		defer server.Close()
+
+		readChan := make(chan map[string]string, 10)
+		var readData []byte
+
+		err := c.Read(func(data []byte, metadata map[string]string) ([]byte, error) {
+			readData = data
+			readChan <- metadata
+			return nil, nil
+		})
+		assert.NoError(t, err)
+
+		params := make(map[string]string)
+		params["path"] = server.URL + "/trader_input.csv"
+		params["poll_interval"] = "50ms"
+
+		var epoch time.Time
+		var period time.Duration
+		var interval time.Duration
+
+		err = c.Init(epoch, period, interval, params)
+		assert.NoError(t, err)
+
+		metadata := <-readChan
+		assert.Equal(t, string(content), string(readData))
+		assert.Equal(t, fmt.Sprintf("%d", len(content)), metadata["size"])
+		assert.Equal(t, lastModified.Format(time.RFC3339Nano), metadata["mod_time"])
+
+		// Unchanged content is not delivered again
+		time.Sleep(200 * time.Millisecond)
+		assert.Len(t, readChan, 0)
+
+		contentMutex.Lock()
+		assert.Greater(t, notModifiedCount, 0)
+		content = []byte("time,value\n1633046400,1\n1633050000,2\n")
+		lastModified = lastModified.Add(time.Hour)
+		etag = `"v2"`
+		contentMutex.Unlock()
+
+		select {
+		case metadata = <-readChan:
+			assert.Equal(t, fmt.Sprintf("%d", len(content)), metadata["size"])
+			assert.Equal(t, lastModified.Format(time.RFC3339Nano), metadata["mod_time"])
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for changed content to be read")
+		}
+	}
+}
+
+func testInitSandboxFunc(params map[string]string, sandbox *file.PathSandbox, expectErr bool) func(*testing.T) {
+	c := file.NewFileConnector()
+
+	return func(t *testing.T) {
+		file.SetPathSandbox(sandbox)
+		t.Cleanup(func() {
+			file.SetPathSandbox(nil)
+		})
+
+		var epoch time.Time
+		var period time.Duration
+		var interval time.Duration
+
+		err := c.Init(epoch, period, interval, params)
+		if expectErr {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package file
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/logrusorgru/aurora"
+)
+
+const (
+	defaultPollInterval = time.Minute
+	defaultHTTPTimeout  = 30 * time.Second
+)
+
+func isURL(path string) bool {
+	lowerPath := strings.ToLower(path)
+	return strings.HasPrefix(lowerPath, "http://") || strings.HasPrefix(lowerPath, "https://")
+}
+
+// Describes remote content so it can be reported like a local file
+type urlFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i *urlFileInfo) Name() string       { return i.name }
+func (i *urlFileInfo) Size() int64        { return i.size }
+func (i *urlFileInfo) Mode() fs.FileMode  { return 0444 }
+func (i *urlFileInfo) ModTime() time.Time { return i.modTime }
+func (i *urlFileInfo) IsDir() bool        { return false }
+func (i *urlFileInfo) Sys() interface{}   { return nil }
+
+func (c *FileConnector) initURL(params map[string]string) error {
+	parsedURL, err := url.Parse(c.path)
+	if err != nil {
+		return fmt.Errorf("invalid url '%s': %w", c.path, err)
+	}
+	c.url = parsedURL
+
+	err = c.checkURL(parsedURL)
+	if err != nil {
+		return err
+	}
+
+	c.pollInterval = defaultPollInterval
+	if pollInterval, ok := params["poll_interval"]; ok {
+		pi, err := time.ParseDuration(pollInterval)
+		if err != nil {
+			return fmt.Errorf("invalid poll_interval '%s': %s", pollInterval, err)
+		}
+		if pi < 0 {
+			return fmt.Errorf("invalid poll_interval '%s': interval must be >= 0", pollInterval)
+		}
+		c.pollInterval = pi
+	}
+
+	c.httpClient = &http.Client{
+		Timeout: defaultHTTPTimeout,
+		// Redirects are checked too so an allowed host can't redirect to a disallowed one
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return c.checkURL(req.URL)
+		},
+	}
+
+	changed, err := c.loadURLData()
+	if err != nil {
+		return err
+	}
+	if changed {
+		err = c.sendData()
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.pollInterval > 0 {
+		c.pollURL()
+	}
+
+	return nil
+}
+
+func (c *FileConnector) pollURL() {
+	ticker := time.NewTicker(c.pollInterval)
+
+	log.Println(fmt.Sprintf("polling '%s' every %s for updates", c.path, c.pollInterval))
+
+	go func() {
+		for range ticker.C {
+			changed, err := c.loadURLData()
+			if err != nil {
+				log.Println(fmt.Errorf("error polling '%s': %w", c.path, err))
+				continue
+			}
+			if changed {
+				err = c.sendData()
+				if err != nil {
+					log.Println(fmt.Errorf("error processing '%s': %w", c.path, err))
+				}
+			}
+		}
+	}()
+}
+
+// Fetches the URL with a conditional request and returns whether its content changed.
+// The fetch runs unlocked so a slow server doesn't block readers of the current data.
+func (c *FileConnector) loadURLData() (bool, error) {
+	loadStartTime := time.Now()
+
+	req, err := http.NewRequest(http.MethodGet, c.url.String(), nil)
+	if err != nil {
+		return false, err
+	}
+
+	c.dataMutex.RLock()
+	etag, lastModified := c.etag, c.lastModified
+	c.dataMutex.RUnlock()
+
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch '%s': %w", c.path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return false, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("failed to fetch '%s': %s", c.path, resp.Status)
+	}
+
+	rawData, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, fmt.Errorf("failed to read '%s': %w", c.path, err)
+	}
+
+	data, compression, err := readDecompressed(c.url.Path, c.compression, bytes.NewReader(rawData))
+	if err != nil {
+		return false, fmt.Errorf("failed to read '%s': %w", c.path, err)
+	}
+
+	c.dataMutex.Lock()
+	defer c.dataMutex.Unlock()
+
+	c.etag = resp.Header.Get("ETag")
+	c.lastModified = resp.Header.Get("Last-Modified")
+
+	if c.data != nil && bytes.Equal(c.data, data) {
+		// Server doesn't support conditional requests and content is unchanged
+		return false, nil
+	}
+
+	modTime := time.Now()
+	if lastModified, err := http.ParseTime(c.lastModified); err == nil {
+		modTime = lastModified
+	}
+
+	c.data = data
+	c.dataCompression = compression
+	c.fileInfo = &urlFileInfo{
+		name:    c.url.Path,
+		size:    int64(len(rawData)),
+		modTime: modTime,
+	}
+
+	duration := time.Since(loadStartTime)
+
+	log.Println(aurora.Green(fmt.Sprintf("loaded '%s' in %.2f seconds ...", c.path, duration.Seconds())))
+
+	return true, nil
+}
@@ -0,0 +1,191 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// PathSandbox restricts which local paths file connectors may read.
+// It is configured by the host process and applies in addition to any pod params,
+// so a pod manifest can tighten the sandbox but never relax it.
+type PathSandbox struct {
+	// Directories paths must resolve into. Empty allows any directory.
+	AllowedRoots []string
+	// Rejects paths that resolve outside of the pod's appDirectory
+	Strict bool
+	// Hosts URL paths may be fetched from. URL paths are rejected when the sandbox restricts
+	// local paths and the host isn't listed.
+	AllowedHosts []string
+}
+
+var (
+	hostSandboxMutex sync.RWMutex
+	hostSandbox      *PathSandbox
+)
+
+// Sets the host-wide sandbox applied to every file connector. Pass nil to clear it.
+func SetPathSandbox(sandbox *PathSandbox) {
+	hostSandboxMutex.Lock()
+	defer hostSandboxMutex.Unlock()
+	hostSandbox = sandbox
+}
+
+func getPathSandbox() *PathSandbox {
+	hostSandboxMutex.RLock()
+	defer hostSandboxMutex.RUnlock()
+	return hostSandbox
+}
+
+// Parses a comma-separated list, as used by allowed_roots and allowed_hosts
+func parseAllowedList(allowed string) []string {
+	var values []string
+	for _, value := range strings.Split(allowed, ",") {
+		value = strings.TrimSpace(value)
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// Verifies path against the host sandbox and the connector's own restrictions.
+// Symlinks are resolved so links cannot be used to escape an allowed directory.
+func (c *FileConnector) checkPath(path string) error {
+	resolvedPath, err := resolvePath(path)
+	if err != nil {
+		return fmt.Errorf("failed to resolve path '%s': %w", path, err)
+	}
+
+	strict := c.strictPaths
+	allowedRootSets := [][]string{c.allowedRoots}
+
+	if sandbox := getPathSandbox(); sandbox != nil {
+		strict = strict || sandbox.Strict
+		allowedRootSets = append(allowedRootSets, sandbox.AllowedRoots)
+	}
+
+	if strict {
+		if c.appDir == "" {
+			return fmt.Errorf("path '%s' rejected: strict paths require 'appDirectory' to be set", path)
+		}
+
+		appDir, err := resolvePath(c.appDir)
+		if err != nil {
+			return fmt.Errorf("failed to resolve appDirectory '%s': %w", c.appDir, err)
+		}
+
+		if !isWithinDir(appDir, resolvedPath) {
+			return fmt.Errorf("path '%s' rejected: resolves outside of appDirectory '%s'", path, c.appDir)
+		}
+	}
+
+	for _, allowedRoots := range allowedRootSets {
+		if len(allowedRoots) == 0 {
+			continue
+		}
+
+		allowed := false
+		for _, root := range allowedRoots {
+			resolvedRoot, err := resolvePath(root)
+			if err != nil {
+				return fmt.Errorf("failed to resolve allowed root '%s': %w", root, err)
+			}
+			if isWithinDir(resolvedRoot, resolvedPath) {
+				allowed = true
+				break
+			}
+		}
+
+		if !allowed {
+			return fmt.Errorf("path '%s' rejected: not within an allowed root", path)
+		}
+	}
+
+	return nil
+}
+
+// Verifies a URL path against the host sandbox and the connector's own restrictions.
+// A sandbox that restricts local paths must also list the URL's host, otherwise a pod could
+// use URLs to reach anything the runtime can reach.
+func (c *FileConnector) checkURL(u *url.URL) error {
+	restricted := c.strictPaths || len(c.allowedRoots) > 0
+	allowedHostSets := [][]string{c.allowedHosts}
+
+	if sandbox := getPathSandbox(); sandbox != nil {
+		restricted = restricted || sandbox.Strict || len(sandbox.AllowedRoots) > 0
+		allowedHostSets = append(allowedHostSets, sandbox.AllowedHosts)
+	}
+
+	hostListed := false
+	for _, allowedHosts := range allowedHostSets {
+		if len(allowedHosts) == 0 {
+			continue
+		}
+		hostListed = true
+
+		if !isAllowedHost(allowedHosts, u) {
+			return fmt.Errorf("url '%s' rejected: host '%s' is not an allowed host", u.Redacted(), u.Host)
+		}
+	}
+
+	if restricted && !hostListed {
+		return fmt.Errorf("url '%s' rejected: sandboxed paths require 'allowed_hosts' to be set to fetch urls", u.Redacted())
+	}
+
+	return nil
+}
+
+// Hosts match by name, or by name and port when the allowed host includes a port
+func isAllowedHost(allowedHosts []string, u *url.URL) bool {
+	for _, host := range allowedHosts {
+		if strings.EqualFold(host, u.Hostname()) || strings.EqualFold(host, u.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the absolute path with all symlinks resolved.
+// Paths that don't exist yet are resolved through their nearest existing parent.
+func resolvePath(path string) (string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	existingPath := absPath
+	var remainder []string
+	for {
+		resolved, err := filepath.EvalSymlinks(existingPath)
+		if err == nil {
+			for i := len(remainder) - 1; i >= 0; i-- {
+				resolved = filepath.Join(resolved, remainder[i])
+			}
+			return resolved, nil
+		}
+
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+
+		parent := filepath.Dir(existingPath)
+		if parent == existingPath {
+			return absPath, nil
+		}
+		remainder = append(remainder, filepath.Base(existingPath))
+		existingPath = parent
+	}
+}
+
+func isWithinDir(dir string, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,180 @@
+package influxdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+type backfillChunk struct {
+	start time.Time
+	end   time.Time
+}
+
+// Persisted progress of a chunked backfill so it can resume after a restart
+type backfillCheckpoint struct {
+	WindowStart    time.Time `json:"window_start"`
+	WindowEnd      time.Time `json:"window_end"`
+	CompletedUntil time.Time `json:"completed_until"`
+}
+
+// Splits [start, end) into consecutive chunks of at most chunkSize. Chunks end on multiples of chunkSize
+// since the Unix epoch, which aggregateWindow() aligns its windows to, so no window is split between chunks.
+func splitWindow(start time.Time, end time.Time, chunkSize time.Duration) []*backfillChunk {
+	if chunkSize <= 0 {
+		return []*backfillChunk{{start: start, end: end}}
+	}
+
+	var chunks []*backfillChunk
+	for chunkStart := start; chunkStart.Before(end); {
+		chunkEnd := time.Unix(0, (chunkStart.UnixNano()/int64(chunkSize)+1)*int64(chunkSize)).UTC()
+		if chunkEnd.After(end) {
+			chunkEnd = end
+		}
+		chunks = append(chunks, &backfillChunk{start: chunkStart, end: chunkEnd})
+		chunkStart = chunkEnd
+	}
+
+	return chunks
+}
+
+// Chunks must be a multiple of interval, otherwise an interval straddling two chunks would be
+// aggregated from part of its data in each
+func parseBackfillParams(params map[string]string, interval time.Duration) (time.Duration, string, error) {
+	var chunkSize time.Duration
+	if backfillChunk, ok := params["backfill_chunk"]; ok {
+		cs, err := time.ParseDuration(backfillChunk)
+		if err != nil {
+			return 0, "", fmt.Errorf("invalid backfill_chunk '%s': %s", backfillChunk, err)
+		}
+		if cs < 0 {
+			return 0, "", fmt.Errorf("invalid backfill_chunk '%s': chunk must be >= 0", backfillChunk)
+		}
+		if interval > 0 && cs%interval != 0 {
+			return 0, "", fmt.Errorf("invalid backfill_chunk '%s': chunk must be a multiple of the interval %s", backfillChunk, interval)
+		}
+		chunkSize = cs
+	}
+
+	checkpointPath := params["backfill_checkpoint"]
+	if checkpointPath != "" && !filepath.IsAbs(checkpointPath) {
+		checkpointPath = filepath.Join(params["appDirectory"], checkpointPath)
+	}
+
+	return chunkSize, checkpointPath, nil
+}
+
+// Returns how far a previous backfill of the same window got, if a checkpoint exists
+func (c *InfluxDbConnector) loadBackfillCheckpoint(windowStart time.Time, windowEnd time.Time) (time.Time, error) {
+	if c.backfillCheckpointPath == "" {
+		return time.Time{}, nil
+	}
+
+	checkpointData, err := os.ReadFile(c.backfillCheckpointPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, fmt.Errorf("failed to read backfill_checkpoint '%s': %w", c.backfillCheckpointPath, err)
+	}
+
+	var checkpoint backfillCheckpoint
+	err = json.Unmarshal(checkpointData, &checkpoint)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid backfill_checkpoint '%s': %w", c.backfillCheckpointPath, err)
+	}
+
+	if !checkpoint.WindowStart.Equal(windowStart) || !checkpoint.WindowEnd.Equal(windowEnd) {
+		// Checkpoint is for a different window
+		return time.Time{}, nil
+	}
+
+	return checkpoint.CompletedUntil, nil
+}
+
+func (c *InfluxDbConnector) saveBackfillCheckpoint(windowStart time.Time, windowEnd time.Time, completedUntil time.Time) error {
+	if c.backfillCheckpointPath == "" {
+		return nil
+	}
+
+	checkpointData, err := json.Marshal(&backfillCheckpoint{
+		WindowStart:    windowStart,
+		WindowEnd:      windowEnd,
+		CompletedUntil: completedUntil,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Write then rename so a crash never leaves a partial checkpoint
+	tempPath := c.backfillCheckpointPath + ".tmp"
+	err = os.WriteFile(tempPath, checkpointData, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write backfill_checkpoint '%s': %w", c.backfillCheckpointPath, err)
+	}
+
+	return os.Rename(tempPath, c.backfillCheckpointPath)
+}
+
+// Fetches the fixed epoch window chunk by chunk, delivering each chunk as it arrives.
+// Progress is kept across failures so the next attempt resumes at the first incomplete chunk.
+func (c *InfluxDbConnector) backfill(windowStart time.Time, windowEnd time.Time, interval time.Duration) error {
+	if c.backfillCompletedUntil.IsZero() {
+		completedUntil, err := c.loadBackfillCheckpoint(windowStart, windowEnd)
+		if err != nil {
+			return err
+		}
+		if !completedUntil.IsZero() {
+			log.Printf("InfluxDb connector resuming backfill from %s", completedUntil.Format(time.RFC3339))
+		}
+		c.backfillCompletedUntil = completedUntil
+	}
+
+	chunks := splitWindow(windowStart, windowEnd, c.backfillChunkSize)
+
+	for i, chunk := range chunks {
+		if !chunk.end.After(c.backfillCompletedUntil) {
+			// Already delivered
+			continue
+		}
+
+		chunkStartStr := chunk.start.Format(time.RFC3339)
+		chunkEndStr := chunk.end.Format(time.RFC3339)
+
+		data, err := c.query(chunkStartStr, chunkEndStr, interval)
+		if err != nil {
+			return fmt.Errorf("backfill chunk %d/%d (%s - %s) failed: %w", i+1, len(chunks), chunkStartStr, chunkEndStr, err)
+		}
+
+		c.data = data
+
+		metadata := map[string]string{}
+		metadata["start"] = chunkStartStr
+		metadata["end"] = chunkEndStr
+		if c.backfillChunkSize > 0 {
+			metadata["chunk"] = strconv.Itoa(i + 1)
+			metadata["chunks"] = strconv.Itoa(len(chunks))
+			metadata["backfill_start"] = windowStart.Format(time.RFC3339)
+			metadata["backfill_end"] = windowEnd.Format(time.RFC3339)
+		}
+
+		err = c.sendData(metadata)
+		if err != nil {
+			return err
+		}
+
+		c.backfillCompletedUntil = chunk.end
+
+		err = c.saveBackfillCheckpoint(windowStart, windowEnd, chunk.end)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
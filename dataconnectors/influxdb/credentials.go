@@ -0,0 +1,148 @@
+package influxdb
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+)
+
+// A credential read from a file, such as a mounted secret, that is re-read when the file changes
+type credentialFile struct {
+	param   string
+	path    string
+	modTime time.Time
+	value   string
+}
+
+// Reads the credential from the file named by param, or returns nil if param isn't set
+func newCredentialFile(params map[string]string, param string) (*credentialFile, error) {
+	path, ok := params[param]
+	if !ok {
+		return nil, nil
+	}
+
+	f := &credentialFile{
+		param: param,
+		path:  appPath(params, path),
+	}
+
+	_, err := f.reload(true)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.value == "" {
+		return nil, fmt.Errorf("invalid %s '%s': file is empty", param, path)
+	}
+
+	return f, nil
+}
+
+// Re-reads the file if it was modified, or always if force is set.
+// Returns whether the credential changed.
+func (f *credentialFile) reload(force bool) (bool, error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s '%s': %w", f.param, f.path, err)
+	}
+
+	if !force && info.ModTime().Equal(f.modTime) {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s '%s': %w", f.param, f.path, err)
+	}
+
+	f.modTime = info.ModTime()
+
+	value := strings.TrimSpace(string(data))
+	if value == "" || value == f.value {
+		// Keep the current credential while a rotation is half written
+		return false, nil
+	}
+	f.value = value
+
+	return true, nil
+}
+
+// Picks up rotated credentials, rebuilding the client if the token changed.
+// Returns whether any credential changed.
+func (c *InfluxDbConnector) rotateCredentials(force bool) (bool, error) {
+	c.clientMutex.Lock()
+	defer c.clientMutex.Unlock()
+
+	if c.tokenFile != nil {
+		changed, err := c.tokenFile.reload(force)
+		if err != nil || !changed {
+			return false, err
+		}
+
+		log.Printf("InfluxDb connector token rotated, reconnecting")
+		previousClient := c.client
+		c.client = c.newClient(c.tokenFile.value)
+		if previousClient != nil {
+			previousClient.Close()
+		}
+		return true, nil
+	}
+
+	if c.passwordFile != nil && c.influxQL != nil {
+		changed, err := c.passwordFile.reload(force)
+		if err != nil || !changed {
+			return false, err
+		}
+
+		log.Printf("InfluxDb connector password rotated")
+		c.influxQL.password = c.passwordFile.value
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// Runs query with the latest credentials, retrying once if it fails to authenticate and the credentials have since rotated
+func (c *InfluxDbConnector) withCredentials(query func() ([]byte, error)) ([]byte, error) {
+	_, err := c.rotateCredentials(false)
+	if err != nil {
+		// The current credentials may still be valid
+		log.Printf("InfluxDb connector failed to reload credentials: %s", err.Error())
+	}
+
+	data, err := query()
+	if err == nil || !isAuthError(err) {
+		return data, err
+	}
+
+	rotated, rotateErr := c.rotateCredentials(true)
+	if rotateErr != nil {
+		log.Printf("InfluxDb connector failed to reload credentials: %s", rotateErr.Error())
+	}
+	if !rotated {
+		return nil, err
+	}
+
+	return query()
+}
+
+func (c *InfluxDbConnector) currentClient() influxdb2.Client {
+	c.clientMutex.RLock()
+	defer c.clientMutex.RUnlock()
+	return c.client
+}
+
+// The client's error type is internal, so match on the messages for 401 and 403 responses
+func isAuthError(err error) bool {
+	message := strings.ToLower(err.Error())
+	for _, authMessage := range []string{"unauthorized", "forbidden", "status code 401", "status code 403"} {
+		if strings.Contains(message, authMessage) {
+			return true
+		}
+	}
+	return false
+}
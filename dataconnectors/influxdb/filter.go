@@ -0,0 +1,104 @@
+package influxdb
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Matches a tag key against one or more values, which may contain '*' wildcards
+type tagFilter struct {
+	key    string
+	values []string
+}
+
+// Splits a comma-separated list param, ignoring empty entries
+func parseListParam(param string) []string {
+	var items []string
+	for _, item := range strings.Split(param, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Parses tag predicates of the form "host=web-*,cpu=cpu-total".
+// Repeating a key matches any of its values.
+func parseTagFilters(param string) ([]*tagFilter, error) {
+	var filters []*tagFilter
+	filtersByKey := make(map[string]*tagFilter)
+
+	for _, predicate := range parseListParam(param) {
+		eqIndex := strings.Index(predicate, "=")
+		if eqIndex <= 0 {
+			return nil, fmt.Errorf("invalid tag filter '%s': expected <tag>=<value>", predicate)
+		}
+
+		key := strings.TrimSpace(predicate[:eqIndex])
+		value := strings.TrimSpace(predicate[eqIndex+1:])
+
+		filter, ok := filtersByKey[key]
+		if !ok {
+			filter = &tagFilter{key: key}
+			filtersByKey[key] = filter
+			filters = append(filters, filter)
+		}
+		filter.values = append(filter.values, value)
+	}
+
+	return filters, nil
+}
+
+// Builds a Flux predicate matching column against any of values
+func columnPredicate(column string, values []string) string {
+	predicates := make([]string, len(values))
+	for i, value := range values {
+		if strings.Contains(value, "*") {
+			predicates[i] = fmt.Sprintf("r[%s] =~ %s", fluxString(column), wildcardRegex(value))
+		} else {
+			predicates[i] = fmt.Sprintf("r[%s] == %s", fluxString(column), fluxString(value))
+		}
+	}
+
+	predicate := strings.Join(predicates, " or ")
+	if len(predicates) > 1 {
+		predicate = "(" + predicate + ")"
+	}
+
+	return predicate
+}
+
+func (c *InfluxDbConnector) buildFilters() []string {
+	filters := []string{
+		fmt.Sprintf("filter(fn: (r) => %s)", columnPredicate("_measurement", c.measurements)),
+		fmt.Sprintf("filter(fn: (r) => %s)", columnPredicate("_field", c.fields)),
+	}
+
+	if len(c.tagFilters) > 0 {
+		tagPredicates := make([]string, len(c.tagFilters))
+		for i, tagFilter := range c.tagFilters {
+			tagPredicates[i] = columnPredicate(tagFilter.key, tagFilter.values)
+		}
+		filters = append(filters, fmt.Sprintf("filter(fn: (r) => %s)", strings.Join(tagPredicates, " and ")))
+	}
+
+	return filters
+}
+
+func fluxString(value string) string {
+	quoted := strconv.Quote(value)
+	// Flux interpolates ${...} inside string literals
+	return strings.ReplaceAll(quoted, "${", "\\${")
+}
+
+func wildcardRegex(value string) string {
+	parts := strings.Split(value, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	pattern := "^" + strings.Join(parts, ".*") + "$"
+	return "/" + strings.ReplaceAll(pattern, "/", "\\/") + "/"
+}
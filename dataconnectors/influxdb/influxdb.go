@@ -5,11 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strconv"
 	"sync"
+	"text/template"
 	"time"
 
-	influxdb2 "github.com/influxdata/influxdb-client-go"
-	"github.com/influxdata/influxdb-client-go/domain"
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -22,20 +24,39 @@ var (
 )
 
 type InfluxDbConnector struct {
+	clientMutex  sync.RWMutex
 	client       influxdb2.Client
+	newClient    func(token string) influxdb2.Client
+	tokenFile    *credentialFile
+	passwordFile *credentialFile
+	influxQL     *influxQLClient
 	readHandlers []*func(data []byte, metadata map[string]string) ([]byte, error)
 
 	lastFetchPeriodEnd time.Time
-	lastError          error
+	// Latest _time received in sliding mode, re-read back to lateness on each refresh
+	watermark time.Time
+	lateness  time.Duration
+
+	retryPolicy *retryPolicy
+	statusMutex sync.RWMutex
+	status      ConnectorStatus
+
+	backfillChunkSize      time.Duration
+	backfillCheckpointPath string
+	backfillCompletedUntil time.Time
 
 	dataMutex sync.RWMutex
 	data      []byte
 
 	org             string
 	bucket          string
-	field           string
+	fields          []string
 	fn              string
-	measurement     string
+	quantile        float64
+	createEmpty     bool
+	measurements    []string
+	tagFilters      []*tagFilter
+	queryTemplate   *template.Template
 	refreshInterval time.Duration
 }
 
@@ -43,6 +64,8 @@ func NewInfluxDbConnector() *InfluxDbConnector {
 	return &InfluxDbConnector{
 		refreshInterval: 15 * time.Second,
 		dataMutex:       sync.RWMutex{},
+		retryPolicy:     defaultRetryPolicy(),
+		status:          ConnectorStatus{State: StatusOk},
 	}
 }
 
@@ -51,12 +74,60 @@ func (c *InfluxDbConnector) Init(epoch time.Time, period time.Duration, interval
 		return errors.New("influxdb connector requires the 'url' parameter to be set")
 	}
 
-	if _, ok := params["token"]; !ok {
-		return errors.New("influxdb connector requires the 'token' parameter to be set")
+	transportOptions, err := parseTransportOptions(params)
+	if err != nil {
+		return err
 	}
 
-	client := influxdb2.NewClient(params["url"], params["token"])
-	c.SetInfluxdbClient(client)
+	if params["version"] == influxQLVersion {
+		influxQL, err := newInfluxQLClient(params, transportOptions.httpClient())
+		if err != nil {
+			return err
+		}
+		c.influxQL = influxQL
+
+		passwordFile, err := newCredentialFile(params, "password_file")
+		if err != nil {
+			return err
+		}
+		if passwordFile != nil {
+			c.passwordFile = passwordFile
+			c.influxQL.password = passwordFile.value
+		}
+
+		if params["measurement"] == "" || params["field"] == "" {
+			return errors.New("influxdb connector requires the 'measurement' and 'field' parameters to be set for version 1")
+		}
+	} else {
+		token, hasToken := params["token"]
+		_, hasTokenFile := params["token_file"]
+		if hasToken && hasTokenFile {
+			return errors.New("only one of 'token' and 'token_file' may be set")
+		}
+		if !hasToken && !hasTokenFile {
+			return errors.New("influxdb connector requires the 'token' parameter to be set")
+		}
+
+		// Tokens read from token_file are reloaded when the file changes or a query fails to authenticate
+		tokenFile, err := newCredentialFile(params, "token_file")
+		if err != nil {
+			return err
+		}
+		if tokenFile != nil {
+			c.tokenFile = tokenFile
+			token = tokenFile.value
+		}
+
+		clientOptions := transportOptions.clientOptions()
+
+		if c.newClient == nil {
+			c.newClient = func(token string) influxdb2.Client {
+				return influxdb2.NewClientWithOptions(params["url"], token, clientOptions)
+			}
+		}
+
+		c.SetInfluxdbClient(c.newClient(token))
+	}
 
 	if org, ok := params["org"]; ok {
 		c.org = org
@@ -66,27 +137,70 @@ func (c *InfluxDbConnector) Init(epoch time.Time, period time.Duration, interval
 		c.bucket = bucket
 	}
 
-	if field, ok := params["field"]; ok {
-		c.field = field
-	} else {
+	// Fields and measurements may be comma-separated lists
+	c.fields = parseListParam(params["field"])
+	if len(c.fields) == 0 {
 		// Default to _value
-		c.field = "_value"
+		c.fields = []string{"_value"}
 	}
 
-	if fn, ok := params["fn"]; ok {
-		c.fn = fn
-	} else {
-		// Default to "mean"
-		c.fn = "mean"
+	fn, quantile, createEmpty, err := parseAggregateParams(params)
+	if err != nil {
+		return err
 	}
+	c.fn = fn
+	c.quantile = quantile
+	c.createEmpty = createEmpty
 
-	if measurement, ok := params["measurement"]; ok {
-		c.measurement = measurement
-	} else {
+	c.measurements = parseListParam(params["measurement"])
+	if len(c.measurements) == 0 {
 		// Default to _measurement
-		c.measurement = "_measurement"
+		c.measurements = []string{"_measurement"}
 	}
 
+	tagFilters, err := parseTagFilters(params["tags"])
+	if err != nil {
+		return err
+	}
+	c.tagFilters = tagFilters
+
+	queryTemplate, err := parseQueryTemplate(params)
+	if err != nil {
+		return err
+	}
+	c.queryTemplate = queryTemplate
+
+	backfillChunkSize, backfillCheckpointPath, err := parseBackfillParams(params, interval)
+	if err != nil {
+		return err
+	}
+	c.backfillChunkSize = backfillChunkSize
+	c.backfillCheckpointPath = backfillCheckpointPath
+
+	validateSchema := false
+	if paramValidateSchema, ok := params["validate_schema"]; ok {
+		vs, err := strconv.ParseBool(paramValidateSchema)
+		if err != nil {
+			return fmt.Errorf("invalid validate_schema '%s': %s", paramValidateSchema, err)
+		}
+		if vs && c.influxQL != nil {
+			return errors.New("influxdb connector only supports the 'validate_schema' parameter for version 2")
+		}
+		validateSchema = vs
+	}
+
+	lateness, err := parseLateness(params, interval)
+	if err != nil {
+		return err
+	}
+	c.lateness = lateness
+
+	retryPolicy, err := parseRetryPolicy(params)
+	if err != nil {
+		return err
+	}
+	c.retryPolicy = retryPolicy
+
 	if refreshInterval, ok := params["refresh_interval"]; ok {
 		ri, err := time.ParseDuration(refreshInterval)
 		if err != nil {
@@ -98,40 +212,110 @@ func (c *InfluxDbConnector) Init(epoch time.Time, period time.Duration, interval
 		c.refreshInterval = ri
 	}
 
-	err := c.refreshData(epoch, period, interval)
+	if validateSchema {
+		validateStart := now().UTC().Add(-period)
+		if !epoch.IsZero() {
+			validateStart = epoch.UTC()
+		}
+		err = c.validateSchema(validateStart, validateStart.Add(period))
+		if err != nil {
+			return err
+		}
+	}
+
+	if !epoch.IsZero() && c.backfillChunkSize > 0 {
+		// A chunked backfill can take a long time, so it runs in the refresher rather than
+		// blocking Init, and failed chunks are retried according to the retry policy
+		go c.refresh(epoch, period, interval, 0)
+		return nil
+	}
+
+	err = c.refreshData(epoch, period, interval)
 	if err != nil {
+		c.recordFailure(err)
 		return err
 	}
+	c.recordSuccess()
 
 	if c.refreshInterval > 0 {
-		ticker := time.NewTicker(c.refreshInterval)
-		done := make(chan bool)
-		go func() {
-			for {
-				select {
-				case <-done:
-					return
-				case <-ticker.C:
-					err := c.refreshData(epoch, period, interval)
-					if err != nil && c.lastError != nil {
-						// Two errors in a row, stop refresh
-						log.Printf("InfluxDb connector refresh error: %s\n", c.lastError.Error())
-						return
-					}
-					c.lastError = err
-				}
-			}
-		}()
+		go c.refresh(epoch, period, interval, c.refreshInterval)
 	}
 
 	return nil
 }
 
+// Status returns the current state of the refresher, including any retry in progress
+func (c *InfluxDbConnector) Status() ConnectorStatus {
+	c.statusMutex.RLock()
+	defer c.statusMutex.RUnlock()
+	return c.status
+}
+
 func (c *InfluxDbConnector) Read(handler func(data []byte, metadata map[string]string) ([]byte, error)) error {
 	c.readHandlers = append(c.readHandlers, &handler)
 	return nil
 }
 
+// Refreshes every refreshInterval, starting after initialDelay, and backs off according to the retry policy
+// while refreshes fail. Without a refreshInterval it stops after the first successful refresh.
+func (c *InfluxDbConnector) refresh(epoch time.Time, period time.Duration, interval time.Duration, initialDelay time.Duration) {
+	timer := time.NewTimer(initialDelay)
+	defer timer.Stop()
+
+	for range timer.C {
+		err := c.refreshData(epoch, period, interval)
+		if err == nil {
+			c.recordSuccess()
+			if c.refreshInterval <= 0 {
+				return
+			}
+			timer.Reset(c.refreshInterval)
+			continue
+		}
+
+		failures := c.recordFailure(err)
+		if !c.retryPolicy.shouldRetry(failures) {
+			log.Printf("InfluxDb connector refresh stopped after %d failed attempts: %s\n", failures, err.Error())
+			c.statusMutex.Lock()
+			c.status.State = StatusStopped
+			c.status.NextRetryTime = time.Time{}
+			c.statusMutex.Unlock()
+			return
+		}
+
+		delay := c.retryPolicy.delay(failures)
+		log.Printf("InfluxDb connector refresh error (attempt %d), retrying in %s: %s\n", failures, delay, err.Error())
+
+		c.statusMutex.Lock()
+		c.status.NextRetryTime = time.Now().Add(delay)
+		c.statusMutex.Unlock()
+
+		timer.Reset(delay)
+	}
+}
+
+func (c *InfluxDbConnector) recordSuccess() {
+	c.statusMutex.Lock()
+	defer c.statusMutex.Unlock()
+
+	c.status.State = StatusOk
+	c.status.ConsecutiveFailures = 0
+	c.status.LastSuccessTime = time.Now()
+	c.status.NextRetryTime = time.Time{}
+}
+
+func (c *InfluxDbConnector) recordFailure(err error) int {
+	c.statusMutex.Lock()
+	defer c.statusMutex.Unlock()
+
+	c.status.State = StatusRetrying
+	c.status.ConsecutiveFailures++
+	c.status.LastError = err.Error()
+	c.status.LastErrorTime = time.Now()
+
+	return c.status.ConsecutiveFailures
+}
+
 func (c *InfluxDbConnector) refreshData(epoch time.Time, period time.Duration, interval time.Duration) error {
 	c.dataMutex.Lock()
 	defer c.dataMutex.Unlock()
@@ -140,16 +324,8 @@ func (c *InfluxDbConnector) refreshData(epoch time.Time, period time.Duration, i
 	var periodEnd time.Time
 
 	if epoch.IsZero() {
-		// Epoch not set - sliding window from now
-		if c.lastFetchPeriodEnd.IsZero() {
-			// fetch period from now
-			periodStart = now().UTC().Add(-period)
-			periodEnd = periodStart.Add(period)
-		} else {
-			// If we've already fetched, only fetch the difference with an interval overlap
-			periodStart = c.lastFetchPeriodEnd.Add(-interval)
-			periodEnd = c.lastFetchPeriodEnd.Add(period)
-		}
+		// Epoch not set - sliding window up to now, starting from the latest data already received
+		periodStart, periodEnd = c.slidingWindow(period)
 	} else {
 		// Epoch set - always same window
 		if !c.lastFetchPeriodEnd.IsZero() {
@@ -158,6 +334,20 @@ func (c *InfluxDbConnector) refreshData(epoch time.Time, period time.Duration, i
 		}
 		periodStart = epoch.UTC()
 		periodEnd = periodStart.Add(period)
+
+		if !periodStart.Before(periodEnd) {
+			// No data to fetch
+			return nil
+		}
+
+		err := c.backfill(periodStart, periodEnd, interval)
+		if err != nil {
+			log.Printf("InfluxDb backfill failed: %v", err)
+			return err
+		}
+
+		c.lastFetchPeriodEnd = periodEnd
+		return nil
 	}
 
 	if periodStart == periodEnd || periodStart.After(periodEnd) {
@@ -168,52 +358,75 @@ func (c *InfluxDbConnector) refreshData(epoch time.Time, period time.Duration, i
 	periodStartStr := periodStart.Format(time.RFC3339)
 	periodEndStr := periodEnd.Format(time.RFC3339)
 
-	query := fmt.Sprintf(`
-		from(bucket:"%s") |>
-		range(start: %s, stop: %s) |>
-		filter(fn: (r) => r["_measurement"] == "%s") |>
-		filter(fn: (r) => r["_field"] == "%s") |>
-		aggregateWindow(every: %s, fn: mean, createEmpty: false)
-    `, c.bucket, periodStartStr, periodEndStr, c.measurement, c.field, interval.String())
-
-	header := true
-	annotations := []domain.DialectAnnotations{"group", "datatype", "default"}
-	dateTimeFormat := domain.DialectDateTimeFormatRFC3339
-	dialect := &domain.Dialect{
-		Header:         &header,
-		Annotations:    &annotations,
-		DateTimeFormat: &dateTimeFormat,
-	}
-
-	result, err := c.client.QueryAPI(c.org).QueryRaw(context.Background(), query, dialect)
+	data, err := c.query(periodStartStr, periodEndStr, interval)
 	if err != nil {
 		log.Printf("InfluxDb query failed: %v", err)
 		return err
 	}
 
-	data := []byte(result)
-
 	c.data = data
 	c.lastFetchPeriodEnd = periodEnd
 
-	err = c.sendData(periodStartStr, periodEndStr)
+	metadata := map[string]string{}
+	metadata["start"] = periodStartStr
+	metadata["end"] = periodEndStr
+
+	err = c.sendData(metadata)
 	if err != nil {
 		return err
 	}
 
+	// Only advance once delivered, so a failed delivery is fetched again
+	if maxTime, ok := maxRecordTime(data); ok && maxTime.After(c.watermark) {
+		c.watermark = maxTime
+	}
+
 	return nil
 }
 
-func (c *InfluxDbConnector) sendData(periodStart string, periodEnd string) error {
+// Queries the window and returns the result as Flux annotated CSV
+func (c *InfluxDbConnector) query(periodStart string, periodEnd string, interval time.Duration) ([]byte, error) {
+	if c.influxQL != nil {
+		return c.withCredentials(func() ([]byte, error) {
+			return c.queryInfluxQL(periodStart, periodEnd, interval)
+		})
+	}
+
+	query, err := c.buildQuery(periodStart, periodEnd, interval)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.withCredentials(func() ([]byte, error) {
+		return c.queryRaw(query)
+	})
+}
+
+// Runs a Flux query and returns the raw annotated CSV
+func (c *InfluxDbConnector) queryRaw(query string) ([]byte, error) {
+	header := true
+	annotations := []domain.DialectAnnotations{"group", "datatype", "default"}
+	dateTimeFormat := domain.DialectDateTimeFormatRFC3339
+	dialect := &domain.Dialect{
+		Header:         &header,
+		Annotations:    &annotations,
+		DateTimeFormat: &dateTimeFormat,
+	}
+
+	result, err := c.currentClient().QueryAPI(c.org).QueryRaw(context.Background(), query, dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(result), nil
+}
+
+func (c *InfluxDbConnector) sendData(metadata map[string]string) error {
 	if len(c.readHandlers) == 0 {
 		// Nothing to read
 		return nil
 	}
 
-	metadata := map[string]string{}
-	metadata["start"] = periodStart
-	metadata["end"] = periodEnd
-
 	errGroup, _ := errgroup.WithContext(context.Background())
 
 	for _, handler := range c.readHandlers {
@@ -228,6 +441,9 @@ func (c *InfluxDbConnector) sendData(periodStart string, periodEnd string) error
 }
 
 func (c *InfluxDbConnector) SetInfluxdbClient(client influxdb2.Client) {
+	c.clientMutex.Lock()
+	defer c.clientMutex.Unlock()
+
 	if c.client == nil {
 		c.client = client
 	}
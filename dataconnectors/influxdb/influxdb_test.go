@@ -1,55 +1,1014 @@
 package influxdb
 
 import (
+	"compress/gzip"
 	"context"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/bradleyjkemp/cupaloy"
-	influxdb2 "github.com/influxdata/influxdb-client-go"
-	"github.com/influxdata/influxdb-client-go/api"
-	"github.com/influxdata/influxdb-client-go/domain"
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	influxhttp "github.com/influxdata/influxdb-client-go/v2/api/http"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
 	"github.com/jonboulle/clockwork"
+	"github.com/spiceai/data-components-contrib/dataprocessors/flux"
+	"github.com/spiceai/spiceai/pkg/observations"
 	"github.com/stretchr/testify/assert"
 )
 
 var snapshotter = cupaloy.New(cupaloy.SnapshotSubdirectory("../../test/assets/snapshots/dataconnectors/influxdb"))
 
 type mockClient struct {
-	queryAPIFunc func(org string) api.QueryAPI
+	queryAPIFunc         func(org string) api.QueryAPI
+	writeAPIBlockingFunc func(org, bucket string) api.WriteAPIBlocking
 }
 
 func (c *mockClient) Setup(ctx context.Context, username, password, org, bucket string, retentionPeriodHours int) (*domain.OnboardingResponse, error) {
 	return nil, nil
 }
-func (c *mockClient) Ready(ctx context.Context) (bool, error)                  { return true, nil }
-func (c *mockClient) Health(ctx context.Context) (*domain.HealthCheck, error)  { return nil, nil }
-func (c *mockClient) Close()                                                   {}
-func (c *mockClient) Options() *influxdb2.Options                              { return nil }
-func (c *mockClient) ServerURL() string                                        { return "" }
-func (c *mockClient) ServerUrl() string                                        { return "" }
-func (c *mockClient) WriteAPI(org, bucket string) api.WriteAPI                 { return nil }
-func (c *mockClient) WriteApi(org, bucket string) api.WriteApi                 { return nil }
-func (c *mockClient) WriteAPIBlocking(org, bucket string) api.WriteAPIBlocking { return nil }
-func (c *mockClient) WriteApiBlocking(org, bucket string) api.WriteApiBlocking { return nil }
+func (c *mockClient) Ready(ctx context.Context) (bool, error)                 { return true, nil }
+func (c *mockClient) Health(ctx context.Context) (*domain.HealthCheck, error) { return nil, nil }
+func (c *mockClient) Close()                                                  {}
+func (c *mockClient) Options() *influxdb2.Options                             { return nil }
+func (c *mockClient) ServerURL() string                                       { return "" }
+func (c *mockClient) WriteAPI(org, bucket string) api.WriteAPI                { return nil }
+func (c *mockClient) WriteAPIBlocking(org, bucket string) api.WriteAPIBlocking {
+	return c.writeAPIBlockingFunc(org, bucket)
+}
 func (c *mockClient) QueryAPI(org string) api.QueryAPI {
 	return c.queryAPIFunc(org)
 }
-func (c *mockClient) QueryApi(org string) api.QueryApi         { return nil }
 func (c *mockClient) AuthorizationsAPI() api.AuthorizationsAPI { return nil }
-func (c *mockClient) AuthorizationsApi() api.AuthorizationsApi { return nil }
 func (c *mockClient) OrganizationsAPI() api.OrganizationsAPI   { return nil }
-func (c *mockClient) OrganizationsApi() api.OrganizationsApi   { return nil }
 func (c *mockClient) UsersAPI() api.UsersAPI                   { return nil }
-func (c *mockClient) UsersApi() api.UsersApi                   { return nil }
 func (c *mockClient) DeleteAPI() api.DeleteAPI                 { return nil }
-func (c *mockClient) DeleteApi() api.DeleteApi                 { return nil }
 func (c *mockClient) BucketsAPI() api.BucketsAPI               { return nil }
-func (c *mockClient) BucketsApi() api.BucketsApi               { return nil }
 func (c *mockClient) LabelsAPI() api.LabelsAPI                 { return nil }
-func (c *mockClient) LabelsApi() api.LabelsApi                 { return nil }
+func (c *mockClient) TasksAPI() api.TasksAPI                   { return nil }
+func (c *mockClient) HTTPService() influxhttp.Service          { return nil }
+
+func TestInfluxDbConnectorRetry(t *testing.T) {
+	// Sliding window so every refresh queries
+	var epoch time.Time
+	period := 3 * 24 * time.Hour
+	interval := time.Hour
+
+	params := map[string]string{
+		"url":                    "fake-url-for-test",
+		"token":                  "fake-token-for-test",
+		"refresh_interval":       "20ms",
+		"retry_initial_interval": "10ms",
+		"retry_max_interval":     "40ms",
+		"retry_jitter":           "0",
+	}
+
+	newConnector := func(failures int32) (*InfluxDbConnector, *int32) {
+		c := NewInfluxDbConnector()
+
+		var queryCount int32
+		mockQueryAPI := &mockQueryAPI{}
+		mockQueryAPI.setQueryRaw(func(ctx context.Context, query string, dialect *domain.Dialect) (string, error) {
+			count := atomic.AddInt32(&queryCount, 1)
+			// Initial query succeeds, then the following refreshes fail
+			if count > 1 && (failures < 0 || count <= failures+1) {
+				return "", fmt.Errorf("connection refused")
+			}
+			return "query-result", nil
+		})
+		c.SetInfluxdbClient(&mockClient{
+			queryAPIFunc: func(org string) api.QueryAPI {
+				return mockQueryAPI
+			},
+		})
+
+		err := c.Read(func(data []byte, metadata map[string]string) ([]byte, error) {
+			return nil, nil
+		})
+		assert.NoError(t, err)
+
+		return c, &queryCount
+	}
+
+	t.Run("Status() retrying then recovered", func(t *testing.T) {
+		c, _ := newConnector(3)
+
+		err := c.Init(epoch, period, interval, params)
+		assert.NoError(t, err)
+		assert.Equal(t, StatusOk, c.Status().State)
+
+		assert.Eventually(t, func() bool {
+			status := c.Status()
+			return status.State == StatusRetrying && status.ConsecutiveFailures == 2
+		}, time.Second, 5*time.Millisecond)
+
+		status := c.Status()
+		assert.Equal(t, "connection refused", status.LastError)
+		assert.False(t, status.NextRetryTime.IsZero())
+
+		assert.Eventually(t, func() bool {
+			return c.Status().State == StatusOk
+		}, time.Second, 5*time.Millisecond)
+
+		status = c.Status()
+		assert.Equal(t, 0, status.ConsecutiveFailures)
+		assert.True(t, status.NextRetryTime.IsZero())
+		assert.True(t, status.LastSuccessTime.After(status.LastErrorTime))
+	})
+
+	t.Run("Status() stopped after max attempts", func(t *testing.T) {
+		maxAttemptsParams := map[string]string{"retry_max_attempts": "2"}
+		for key, value := range params {
+			maxAttemptsParams[key] = value
+		}
+
+		c, queryCount := newConnector(-1)
+
+		err := c.Init(epoch, period, interval, maxAttemptsParams)
+		assert.NoError(t, err)
+
+		assert.Eventually(t, func() bool {
+			return c.Status().State == StatusStopped
+		}, time.Second, 5*time.Millisecond)
+
+		assert.Equal(t, 2, c.Status().ConsecutiveFailures)
+
+		// No further attempts once stopped
+		time.Sleep(100 * time.Millisecond)
+		assert.Equal(t, int32(3), atomic.LoadInt32(queryCount))
+	})
+
+	t.Run("delay()", func(t *testing.T) {
+		policy := &retryPolicy{
+			initialInterval: time.Second,
+			maxInterval:     5 * time.Second,
+			multiplier:      2,
+		}
+		assert.Equal(t, time.Second, policy.delay(1))
+		assert.Equal(t, 2*time.Second, policy.delay(2))
+		assert.Equal(t, 4*time.Second, policy.delay(3))
+		assert.Equal(t, 5*time.Second, policy.delay(4))
+
+		policy.jitter = 0.5
+		for i := 0; i < 100; i++ {
+			delay := policy.delay(2)
+			assert.GreaterOrEqual(t, int64(delay), int64(time.Second))
+			assert.LessOrEqual(t, int64(delay), int64(3*time.Second))
+		}
+	})
+
+	t.Run("Init() invalid retry_initial_interval", testInitInvalidFunc(map[string]string{"retry_initial_interval": "soon"}))
+	t.Run("Init() invalid retry_max_interval", testInitInvalidFunc(map[string]string{"retry_initial_interval": "1m", "retry_max_interval": "1s"}))
+	t.Run("Init() invalid retry_multiplier", testInitInvalidFunc(map[string]string{"retry_multiplier": "0.5"}))
+	t.Run("Init() invalid retry_jitter", testInitInvalidFunc(map[string]string{"retry_jitter": "2"}))
+	t.Run("Init() invalid retry_max_attempts", testInitInvalidFunc(map[string]string{"retry_max_attempts": "-1"}))
+}
+
+func TestInfluxDbConnectorWatermark(t *testing.T) {
+	clock := clockwork.NewFakeClockAt(time.Unix(1633421096, 0))
+	now = clock.Now
+	t.Cleanup(func() {
+		now = time.Now
+	})
+
+	var epoch time.Time
+	period := 7 * 24 * time.Hour
+	interval := time.Hour
+
+	params := map[string]string{
+		"url":              "fake-url-for-test",
+		"token":            "fake-token-for-test",
+		"refresh_interval": "0",
+		"lateness":         "5m",
+	}
+
+	var ranges []string
+	var resultTimes []string
+	mockQueryAPI := &mockQueryAPI{}
+	mockQueryAPI.setQueryRaw(func(ctx context.Context, query string, dialect *domain.Dialect) (string, error) {
+		rangeStart := strings.Index(query, "range(")
+		ranges = append(ranges, query[rangeStart:rangeStart+strings.Index(query[rangeStart:], ")")+1])
+
+		result := "#datatype,string,long,dateTime:RFC3339,double\n,result,table,_time,_value\n"
+		for _, resultTime := range resultTimes {
+			result += fmt.Sprintf(",,0,%s,1\n", resultTime)
+		}
+		return result, nil
+	})
+
+	c := NewInfluxDbConnector()
+	c.SetInfluxdbClient(&mockClient{
+		queryAPIFunc: func(org string) api.QueryAPI {
+			return mockQueryAPI
+		},
+	})
+
+	var deliveryErr error
+	err := c.Read(func(data []byte, metadata map[string]string) ([]byte, error) {
+		return nil, deliveryErr
+	})
+	assert.NoError(t, err)
+
+	// Initial fetch covers the whole period up to now
+	resultTimes = []string{"2021-10-05T07:00:00Z", "2021-10-05T07:54:56Z"}
+	err = c.Init(epoch, period, interval, params)
+	assert.NoError(t, err)
+
+	// Next fetch starts from the latest _time received less the allowed lateness
+	clock.Advance(time.Hour)
+	resultTimes = nil
+	err = c.refreshData(epoch, period, interval)
+	assert.NoError(t, err)
+
+	// Empty results don't advance the watermark
+	clock.Advance(time.Hour)
+	resultTimes = []string{"2021-10-05T08:30:00Z"}
+	err = c.refreshData(epoch, period, interval)
+	assert.NoError(t, err)
+
+	// Failed deliveries don't advance the watermark
+	clock.Advance(time.Hour)
+	resultTimes = []string{"2021-10-05T11:00:00Z"}
+	deliveryErr = fmt.Errorf("handler failed")
+	err = c.refreshData(epoch, period, interval)
+	assert.Error(t, err)
+
+	clock.Advance(time.Hour)
+	resultTimes = nil
+	deliveryErr = nil
+	err = c.refreshData(epoch, period, interval)
+	assert.NoError(t, err)
+
+	// Never fetches further back than the period
+	clock.Advance(8 * 24 * time.Hour)
+	err = c.refreshData(epoch, period, interval)
+	assert.NoError(t, err)
+
+	expectedRanges := []string{
+		"range(start: 2021-09-28T08:04:56Z, stop: 2021-10-05T08:04:56Z)",
+		"range(start: 2021-10-05T07:49:56Z, stop: 2021-10-05T09:04:56Z)",
+		"range(start: 2021-10-05T07:49:56Z, stop: 2021-10-05T10:04:56Z)",
+		"range(start: 2021-10-05T08:25:00Z, stop: 2021-10-05T11:04:56Z)",
+		"range(start: 2021-10-05T08:25:00Z, stop: 2021-10-05T12:04:56Z)",
+		"range(start: 2021-10-06T12:04:56Z, stop: 2021-10-13T12:04:56Z)",
+	}
+	assert.Equal(t, expectedRanges, ranges)
+
+	data, err := os.ReadFile("../../test/assets/data/annotated-csv/cpu_metrics_influxdb_annotated.csv")
+	if assert.NoError(t, err) {
+		maxTime, ok := maxRecordTime(data)
+		assert.True(t, ok)
+		assert.Equal(t, "2021-08-17T22:16:00Z", maxTime.Format(time.RFC3339))
+	}
+
+	_, ok := maxRecordTime([]byte("query-result"))
+	assert.False(t, ok)
+
+	t.Run("Init() invalid lateness", testInitInvalidFunc(map[string]string{"lateness": "-5m"}))
+}
+
+func TestInfluxDbConnectorTransport(t *testing.T) {
+	epoch := time.Unix(1625439600, 0)
+	period := 3 * time.Hour
+	interval := time.Hour
+
+	expectedResult := "#datatype,string,long,dateTime:RFC3339,double\n,result,table,_time,_value\n,,0,2021-07-04T23:00:00Z,1\n"
+
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v2/query", r.URL.Path)
+		assert.Equal(t, "Token fake-token-for-test", r.Header.Get("Authorization"))
+		_, _ = w.Write([]byte(expectedResult))
+	}))
+	defer tlsServer.Close()
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	err := os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tlsServer.Certificate().Raw}), 0600)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	initTLS := func(extraParams map[string]string) ([]byte, error) {
+		params := map[string]string{
+			"url":              tlsServer.URL,
+			"token":            "fake-token-for-test",
+			"refresh_interval": "0",
+			"timeout":          "5s",
+		}
+		for key, value := range extraParams {
+			params[key] = value
+		}
+
+		c := NewInfluxDbConnector()
+
+		var readData []byte
+		err := c.Read(func(data []byte, metadata map[string]string) ([]byte, error) {
+			readData = data
+			return nil, nil
+		})
+		assert.NoError(t, err)
+
+		err = c.Init(epoch, period, interval, params)
+		return readData, err
+	}
+
+	t.Run("Init() private CA", func(t *testing.T) {
+		data, err := initTLS(map[string]string{"tls_ca": caPath})
+		assert.NoError(t, err)
+		assert.Equal(t, expectedResult, string(data))
+	})
+
+	t.Run("Init() unknown CA", func(t *testing.T) {
+		_, err := initTLS(nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("Init() insecure skip verify", func(t *testing.T) {
+		data, err := initTLS(map[string]string{"tls_insecure_skip_verify": "true"})
+		assert.NoError(t, err)
+		assert.Equal(t, expectedResult, string(data))
+	})
+
+	t.Run("Init() InfluxQL through proxy", func(t *testing.T) {
+		var proxiedHost string
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			proxiedHost = r.URL.Host
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"results":[{"statement_id":0}]}`))
+		}))
+		defer proxy.Close()
+
+		params := map[string]string{
+			"version":          "1",
+			"url":              "http://influxdb.invalid:8086",
+			"database":         "telegraf",
+			"measurement":      "cpu",
+			"field":            "usage_idle",
+			"refresh_interval": "0",
+			"proxy":            proxy.URL,
+		}
+
+		err := NewInfluxDbConnector().Init(epoch, period, interval, params)
+		assert.NoError(t, err)
+		assert.Equal(t, "influxdb.invalid:8086", proxiedHost)
+	})
+
+	t.Run("Init() InfluxQL timeout", func(t *testing.T) {
+		slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(500 * time.Millisecond)
+		}))
+		defer slowServer.Close()
+
+		params := map[string]string{
+			"version":          "1",
+			"url":              slowServer.URL,
+			"database":         "telegraf",
+			"measurement":      "cpu",
+			"field":            "usage_idle",
+			"refresh_interval": "0",
+			"timeout":          "50ms",
+		}
+
+		err := NewInfluxDbConnector().Init(epoch, period, interval, params)
+		assert.Error(t, err)
+	})
+
+	t.Run("Init() Flux through proxy", func(t *testing.T) {
+		var proxiedHost string
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			proxiedHost = r.URL.Host
+			assert.Equal(t, "/api/v2/query", r.URL.Path)
+			assert.Equal(t, "spice", r.URL.Query().Get("org"))
+			assert.Equal(t, "Token fake-token-for-test", r.Header.Get("Authorization"))
+			_, _ = w.Write([]byte(expectedResult))
+		}))
+		defer proxy.Close()
+
+		data, err := initTLS(map[string]string{
+			"url":   "http://influxdb.invalid:8086",
+			"org":   "spice",
+			"proxy": proxy.URL,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, expectedResult, string(data))
+		assert.Equal(t, "influxdb.invalid:8086", proxiedHost)
+	})
+
+	t.Run("Init() Flux through proxy unauthorized", func(t *testing.T) {
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"code":"unauthorized","message":"unauthorized access"}`))
+		}))
+		defer proxy.Close()
+
+		_, err := initTLS(map[string]string{
+			"url":   "http://influxdb.invalid:8086",
+			"proxy": proxy.URL,
+		})
+		if assert.Error(t, err) {
+			assert.True(t, isAuthError(err))
+		}
+	})
+
+	t.Run("clientOptions() proxy matches direct requests", func(t *testing.T) {
+		newServer := func(requests *[]string, hosts *[]string) *httptest.Server {
+			return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body := io.Reader(r.Body)
+				if r.Header.Get("Content-Encoding") == "gzip" {
+					gzipReader, err := gzip.NewReader(r.Body)
+					if !assert.NoError(t, err) {
+						return
+					}
+					body = gzipReader
+				}
+				data, _ := io.ReadAll(body)
+
+				*hosts = append(*hosts, r.URL.Host)
+				*requests = append(*requests, fmt.Sprintf("%s %s?%s %s %s", r.Method, r.URL.Path, r.URL.RawQuery, r.Header.Get("Authorization"), data))
+
+				switch r.URL.Path {
+				case "/health":
+					w.Header().Set("Content-Type", "application/json")
+					_, _ = w.Write([]byte(`{"name":"influxdb","status":"pass"}`))
+				case "/api/v2/query":
+					_, _ = w.Write([]byte(expectedResult))
+				default:
+					w.WriteHeader(http.StatusNoContent)
+				}
+			}))
+		}
+
+		// Exercises the client beyond raw queries: health, parsed queries, and gzipped writes with default tags
+		useClient := func(serverURL string, transportOptions *transportOptions) []float64 {
+			options := transportOptions.clientOptions()
+			options.SetUseGZip(true)
+			options.AddDefaultTag("host", "a")
+
+			client := influxdb2.NewClientWithOptions(serverURL, "fake-token-for-test", options)
+			defer client.Close()
+
+			_, err := client.Health(context.Background())
+			assert.NoError(t, err)
+
+			var values []float64
+			result, err := client.QueryAPI("spice").Query(context.Background(), `from(bucket: "telegraf")`)
+			if assert.NoError(t, err) {
+				for result.Next() {
+					values = append(values, result.Record().Value().(float64))
+				}
+				assert.NoError(t, result.Err())
+			}
+
+			point := influxdb2.NewPoint("trades", nil, map[string]interface{}{"price": 101.5}, time.Unix(1625439600, 0))
+			err = client.WriteAPIBlocking("spice", "derived").WritePoint(context.Background(), point)
+			assert.NoError(t, err)
+
+			return values
+		}
+
+		var directRequests, directHosts []string
+		direct := newServer(&directRequests, &directHosts)
+		defer direct.Close()
+		directValues := useClient(direct.URL, &transportOptions{})
+
+		var proxiedRequests, proxiedHosts []string
+		proxy := newServer(&proxiedRequests, &proxiedHosts)
+		defer proxy.Close()
+		proxyURL, err := url.Parse(proxy.URL)
+		if !assert.NoError(t, err) {
+			return
+		}
+		proxiedValues := useClient("http://influxdb.invalid:8086", &transportOptions{proxy: proxyURL})
+
+		assert.Equal(t, []float64{1}, directValues)
+		assert.Equal(t, directValues, proxiedValues)
+		if assert.Len(t, directRequests, 3) {
+			assert.Contains(t, directRequests[2], "trades,host=a price=101.5 1625439600")
+		}
+		assert.Equal(t, directRequests, proxiedRequests)
+		assert.Equal(t, []string{"influxdb.invalid:8086", "influxdb.invalid:8086", "influxdb.invalid:8086"}, proxiedHosts)
+	})
+
+	t.Run("Init() invalid proxy", testInitInvalidFunc(map[string]string{"version": "1", "database": "telegraf", "measurement": "cpu", "field": "usage_idle", "proxy": "ftp://proxy.invalid"}))
+	t.Run("Init() invalid timeout", testInitInvalidFunc(map[string]string{"timeout": "0s"}))
+	t.Run("Init() missing tls_ca", testInitInvalidFunc(map[string]string{"tls_ca": filepath.Join(t.TempDir(), "missing.pem")}))
+	t.Run("Init() tls_cert without tls_key", testInitInvalidFunc(map[string]string{"tls_cert": caPath}))
+	t.Run("Init() invalid tls_insecure_skip_verify", testInitInvalidFunc(map[string]string{"tls_insecure_skip_verify": "maybe"}))
+}
+
+func TestInfluxDbConnectorSchema(t *testing.T) {
+	annotatedCsv := func(datatype string, column string, values ...string) string {
+		result := fmt.Sprintf("#datatype,string,long,%s\n#group,false,false,false\n#default,_result,,\n,result,table,%s\n", datatype, column)
+		for _, value := range values {
+			result += fmt.Sprintf(",,0,%s\n", value)
+		}
+		return result
+	}
+
+	var queries []string
+	mockQueryAPI := &mockQueryAPI{}
+	mockQueryAPI.setQueryRaw(func(ctx context.Context, query string, dialect *domain.Dialect) (string, error) {
+		queries = append(queries, query)
+		switch {
+		case strings.HasPrefix(query, "buckets()"):
+			return annotatedCsv("string", "name", "_monitoring", "telegraf"), nil
+		case strings.Contains(query, "schema.measurements("):
+			return annotatedCsv("string", "_value", "mem", "cpu"), nil
+		case strings.Contains(query, "schema.tagKeys(") && strings.Contains(query, `"cpu"`):
+			return annotatedCsv("string", "_value", "_start", "_stop", "_field", "_measurement", "host", "cpu"), nil
+		case strings.Contains(query, "schema.tagKeys("):
+			return annotatedCsv("string", "_value", "_start", "_stop", "host"), nil
+		case strings.Contains(query, "schema.tagValues(") && strings.Contains(query, `tag: "host"`):
+			return annotatedCsv("string", "_value", "web-2", "web-1"), nil
+		case strings.Contains(query, "schema.tagValues("):
+			return annotatedCsv("string", "_value", "cpu-total"), nil
+		case strings.Contains(query, `group(columns: ["_field"])`) && strings.Contains(query, `"cpu"`):
+			return annotatedCsv("string,double", "_field,_value", "usage_user,0.5") + "\n" +
+				annotatedCsv("string,double", "_field,_value", "usage_idle,99.5"), nil
+		case strings.Contains(query, `group(columns: ["_field"])`):
+			return annotatedCsv("string,long", "_field,_value", "used,1024") + "\n" +
+				annotatedCsv("string,boolean", "_field,_value", "swap,true"), nil
+		}
+		return "query-result", nil
+	})
+
+	newConnector := func() *InfluxDbConnector {
+		c := NewInfluxDbConnector()
+		c.SetInfluxdbClient(&mockClient{
+			queryAPIFunc: func(org string) api.QueryAPI {
+				return mockQueryAPI
+			},
+		})
+		return c
+	}
+
+	start := time.Unix(1625439600, 0)
+	stop := start.Add(24 * time.Hour)
+
+	c := newConnector()
+	c.bucket = "telegraf"
+
+	buckets, err := c.ListBuckets()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"_monitoring", "telegraf"}, buckets)
+
+	queries = nil
+	schema, err := c.DiscoverSchema("", start, stop)
+	if assert.NoError(t, err) && assert.Len(t, schema, 2) {
+		assert.Equal(t, &MeasurementSchema{
+			Name: "cpu",
+			Fields: []*FieldSchema{
+				{Name: "usage_idle", Type: "float"},
+				{Name: "usage_user", Type: "float"},
+			},
+			TagKeys:   []string{"cpu", "host"},
+			TagValues: map[string][]string{"cpu": {"cpu-total"}, "host": {"web-1", "web-2"}},
+		}, schema[0])
+		assert.Equal(t, &MeasurementSchema{
+			Name: "mem",
+			Fields: []*FieldSchema{
+				{Name: "swap", Type: "boolean"},
+				{Name: "used", Type: "integer"},
+			},
+			TagKeys:   []string{"host"},
+			TagValues: map[string][]string{"host": {"web-1", "web-2"}},
+		}, schema[1])
+	}
+
+	if assert.NotEmpty(t, queries) {
+		assertEqualQuery(t, `import "influxdata/influxdb/schema"
+schema.measurements(bucket: "telegraf", start: 2021-07-04T23:00:00Z, stop: 2021-07-05T23:00:00Z)`, queries[0])
+		assertEqualQuery(t, `from(bucket: "telegraf") |>
+range(start: 2021-07-04T23:00:00Z, stop: 2021-07-05T23:00:00Z) |>
+filter(fn: (r) => r["_measurement"] == "cpu") |>
+group(columns: ["_field"]) |>
+first() |>
+keep(columns: ["_field", "_value"])`, queries[1])
+	}
+
+	params := map[string]string{
+		"url":              "fake-url-for-test",
+		"token":            "fake-token-for-test",
+		"bucket":           "telegraf",
+		"measurement":      "cpu",
+		"field":            "usage_idle",
+		"refresh_interval": "0",
+		"validate_schema":  "true",
+	}
+
+	t.Run("Init() validate_schema", func(t *testing.T) {
+		err := newConnector().Init(start, 24*time.Hour, time.Hour, params)
+		assert.NoError(t, err)
+	})
+
+	invalidParams := func(key string, value string) map[string]string {
+		invalid := map[string]string{}
+		for k, v := range params {
+			invalid[k] = v
+		}
+		invalid[key] = value
+		return invalid
+	}
+
+	t.Run("Init() validate_schema unknown measurement", func(t *testing.T) {
+		err := newConnector().Init(start, 24*time.Hour, time.Hour, invalidParams("measurement", "disk"))
+		assert.EqualError(t, err, "invalid measurement 'disk': not found in bucket 'telegraf', available measurements are cpu, mem")
+	})
+
+	t.Run("Init() validate_schema unknown field", func(t *testing.T) {
+		err := newConnector().Init(start, 24*time.Hour, time.Hour, invalidParams("field", "usage_idle,usage_system"))
+		assert.EqualError(t, err, "invalid field 'usage_system': not found in measurement 'cpu', available fields are usage_idle, usage_user")
+	})
+
+	t.Run("Init() invalid validate_schema", testInitInvalidFunc(map[string]string{"validate_schema": "sometimes"}))
+}
+
+func TestInfluxDbSink(t *testing.T) {
+	params := map[string]string{
+		"url":                    "fake-url-for-test",
+		"token":                  "fake-token-for-test",
+		"org":                    "spice",
+		"bucket":                 "derived",
+		"measurement":            "trades",
+		"batch_size":             "2",
+		"flush_interval":         "0",
+		"retry_initial_interval": "1ms",
+		"retry_jitter":           "0",
+	}
+
+	newSink := func(failures int) (*InfluxDbSink, *mockWriteAPIBlocking) {
+		writeAPI := &mockWriteAPIBlocking{failures: failures}
+
+		s := NewInfluxDbSink()
+		s.SetInfluxdbClient(&mockClient{
+			writeAPIBlockingFunc: func(org, bucket string) api.WriteAPIBlocking {
+				assert.Equal(t, "spice", org)
+				assert.Equal(t, "derived", bucket)
+				return writeAPI
+			},
+		})
+
+		return s, writeAPI
+	}
+
+	testObservations := []observations.Observation{
+		{Time: 1625439600, Data: map[string]float64{"price": 101.5, "volume": 3}, Tags: []string{"buy", "exchange=nyse", "large"}},
+		{Time: 1625439660, Data: map[string]float64{"price": 102}},
+		// No fields, skipped
+		{Time: 1625439720, Tags: []string{"sell"}},
+		{Time: 1625439780, Data: map[string]float64{"price": 99.25}, Tags: []string{"sell"}},
+	}
+
+	t.Run("Write() batches", func(t *testing.T) {
+		s, writeAPI := newSink(0)
+		err := s.Init(params)
+		assert.NoError(t, err)
+
+		err = s.Write(testObservations)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{
+			"trades,exchange=nyse,tags=buy large price=101.5,volume=3 1625439600\n" +
+				"trades price=102 1625439660\n",
+		}, writeAPI.batches)
+
+		err = s.Close()
+		assert.NoError(t, err)
+		if assert.Len(t, writeAPI.batches, 2) {
+			assert.Equal(t, "trades,tags=sell price=99.25 1625439780\n", writeAPI.batches[1])
+		}
+	})
+
+	t.Run("Init() precision", func(t *testing.T) {
+		precisionParams := map[string]string{"precision": "ms"}
+		for key, value := range params {
+			precisionParams[key] = value
+		}
+
+		s := NewInfluxDbSink()
+		err := s.Init(precisionParams)
+		if assert.NoError(t, err) {
+			assert.Equal(t, time.Millisecond, s.client.Options().WriteOptions().Precision())
+			assert.NoError(t, s.Close())
+		}
+	})
+
+	t.Run("Write() through proxy", func(t *testing.T) {
+		var proxiedHost string
+		var query url.Values
+		var body string
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			proxiedHost = r.URL.Host
+			query = r.URL.Query()
+			data, _ := io.ReadAll(r.Body)
+			body = string(data)
+			assert.Equal(t, "/api/v2/write", r.URL.Path)
+			assert.Equal(t, "Token fake-token-for-test", r.Header.Get("Authorization"))
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer proxy.Close()
+
+		proxyParams := map[string]string{"url": "http://influxdb.invalid:8086", "proxy": proxy.URL, "precision": "s"}
+		for key, value := range params {
+			if _, ok := proxyParams[key]; !ok {
+				proxyParams[key] = value
+			}
+		}
+
+		s := NewInfluxDbSink()
+		err := s.Init(proxyParams)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		err = s.Write(testObservations[:2])
+		assert.NoError(t, err)
+		assert.Equal(t, "influxdb.invalid:8086", proxiedHost)
+		assert.Equal(t, "spice", query.Get("org"))
+		assert.Equal(t, "derived", query.Get("bucket"))
+		assert.Equal(t, "s", query.Get("precision"))
+		assert.Equal(t, "trades,exchange=nyse,tags=buy\\ large price=101.5,volume=3 1625439600\ntrades price=102 1625439660\n", body)
+		assert.NoError(t, s.Close())
+	})
+
+	t.Run("Write() retries", func(t *testing.T) {
+		s, writeAPI := newSink(2)
+		err := s.Init(params)
+		assert.NoError(t, err)
+
+		err = s.Write(testObservations[:2])
+		assert.NoError(t, err)
+		assert.Equal(t, 3, writeAPI.attempts)
+		assert.Len(t, writeAPI.batches, 1)
+	})
+
+	t.Run("Write() retries exhausted", func(t *testing.T) {
+		s, writeAPI := newSink(5)
+		err := s.Init(params)
+		assert.NoError(t, err)
+
+		err = s.Write(testObservations[:2])
+		assert.Error(t, err)
+		assert.Equal(t, 3, writeAPI.attempts)
+		assert.Len(t, writeAPI.batches, 0)
+	})
+
+	t.Run("Write() doesn't block other writers while retrying", func(t *testing.T) {
+		retryParams := map[string]string{"retry_initial_interval": "500ms"}
+		for key, value := range params {
+			if key != "retry_initial_interval" {
+				retryParams[key] = value
+			}
+		}
+
+		s, writeAPI := newSink(1)
+		err := s.Init(retryParams)
+		assert.NoError(t, err)
+
+		writeDone := make(chan error)
+		go func() {
+			writeDone <- s.Write(testObservations[:2])
+		}()
+
+		assert.Eventually(t, func() bool {
+			return writeAPI.getAttempts() == 1
+		}, time.Second, time.Millisecond)
+
+		// Buffers without reaching batch_size while the first batch waits to retry
+		writeStart := time.Now()
+		err = s.Write(testObservations[3:])
+		assert.NoError(t, err)
+		assert.Less(t, int64(time.Since(writeStart)), int64(100*time.Millisecond))
+
+		assert.NoError(t, <-writeDone)
+		assert.NoError(t, s.Close())
+		assert.Len(t, writeAPI.getBatches(), 2)
+	})
+
+	t.Run("Write() periodic flush", func(t *testing.T) {
+		flushParams := map[string]string{"flush_interval": "10ms"}
+		for key, value := range params {
+			if key != "flush_interval" {
+				flushParams[key] = value
+			}
+		}
+
+		s, writeAPI := newSink(0)
+		err := s.Init(flushParams)
+		assert.NoError(t, err)
+
+		err = s.Write(testObservations[1:2])
+		assert.NoError(t, err)
+
+		assert.Eventually(t, func() bool {
+			return len(writeAPI.getBatches()) == 1
+		}, time.Second, 5*time.Millisecond)
+
+		err = s.Close()
+		assert.NoError(t, err)
+	})
+
+	invalidParams := []map[string]string{
+		{"url": "fake-url-for-test", "token": "fake-token-for-test"},
+		{"url": "fake-url-for-test", "bucket": "derived"},
+		{"url": "fake-url-for-test", "token": "fake-token-for-test", "bucket": "derived", "precision": "h"},
+		{"url": "fake-url-for-test", "token": "fake-token-for-test", "bucket": "derived", "batch_size": "0"},
+		{"url": "fake-url-for-test", "token": "fake-token-for-test", "bucket": "derived", "flush_interval": "soon"},
+		{"url": "fake-url-for-test", "token": "fake-token-for-test", "bucket": "derived", "measurement": ""},
+		{"url": "fake-url-for-test", "token": "fake-token-for-test", "bucket": "derived", "retry_max_attempts": "0"},
+	}
+	for _, invalid := range invalidParams {
+		err := NewInfluxDbSink().Init(invalid)
+		assert.Error(t, err)
+	}
+}
+
+func TestInfluxDbConnectorCredentialRotation(t *testing.T) {
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	writeToken := func(token string, modTime time.Time) {
+		err := os.WriteFile(tokenPath, []byte(token+"\n"), 0600)
+		assert.NoError(t, err)
+		err = os.Chtimes(tokenPath, modTime, modTime)
+		assert.NoError(t, err)
+	}
+
+	modTime := time.Unix(1625439600, 0)
+	writeToken("token-1", modTime)
+
+	validToken := "token-1"
+	var clientTokens []string
+	var queryTokens []string
+
+	c := NewInfluxDbConnector()
+	c.newClient = func(token string) influxdb2.Client {
+		clientTokens = append(clientTokens, token)
+		mockQueryAPI := &mockQueryAPI{}
+		mockQueryAPI.setQueryRaw(func(ctx context.Context, query string, dialect *domain.Dialect) (string, error) {
+			queryTokens = append(queryTokens, token)
+			if token != validToken {
+				return "", fmt.Errorf("unauthorized: unauthorized access")
+			}
+			return "query-result", nil
+		})
+		return &mockClient{
+			queryAPIFunc: func(org string) api.QueryAPI {
+				return mockQueryAPI
+			},
+		}
+	}
+
+	err := c.Read(func(data []byte, metadata map[string]string) ([]byte, error) {
+		return nil, nil
+	})
+	assert.NoError(t, err)
+
+	params := map[string]string{
+		"url":              "fake-url-for-test",
+		"token_file":       tokenPath,
+		"refresh_interval": "0",
+	}
+
+	var epoch time.Time
+	period := 24 * time.Hour
+	interval := time.Hour
+
+	err = c.Init(epoch, period, interval, params)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"token-1"}, clientTokens)
+
+	// Rotated token file is picked up before the next query
+	validToken = "token-2"
+	modTime = modTime.Add(time.Minute)
+	writeToken("token-2", modTime)
+	err = c.refreshData(epoch, period, interval)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"token-1", "token-2"}, clientTokens)
+	assert.Equal(t, []string{"token-1", "token-2"}, queryTokens)
+
+	// Rotation the modification time doesn't reveal is picked up after an auth failure
+	validToken = "token-3"
+	writeToken("token-3", modTime)
+	err = c.refreshData(epoch, period, interval)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"token-1", "token-2", "token-3"}, clientTokens)
+	assert.Equal(t, []string{"token-1", "token-2", "token-2", "token-3"}, queryTokens)
+
+	// Auth failures without a new token are returned
+	validToken = "token-4"
+	err = c.refreshData(epoch, period, interval)
+	assert.Error(t, err)
+	assert.Equal(t, []string{"token-1", "token-2", "token-3"}, clientTokens)
+
+	t.Run("Init() InfluxQL password_file", func(t *testing.T) {
+		passwordPath := filepath.Join(t.TempDir(), "password")
+		err := os.WriteFile(passwordPath, []byte("secret-1"), 0600)
+		assert.NoError(t, err)
+
+		validPassword := "secret-1"
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, password, _ := r.BasicAuth()
+			if password != validPassword {
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte(`{"error":"authorization failed"}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"results":[{"statement_id":0}]}`))
+		}))
+		defer server.Close()
+
+		params := map[string]string{
+			"version":          "1",
+			"url":              server.URL,
+			"database":         "telegraf",
+			"measurement":      "cpu",
+			"field":            "usage_idle",
+			"username":         "spice",
+			"password_file":    passwordPath,
+			"refresh_interval": "0",
+		}
+
+		c := NewInfluxDbConnector()
+		err = c.Init(epoch, period, interval, params)
+		assert.NoError(t, err)
+
+		validPassword = "secret-2"
+		err = os.WriteFile(passwordPath, []byte("secret-2"), 0600)
+		assert.NoError(t, err)
+		err = c.refreshData(epoch, period, interval)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Init() token and token_file", testInitInvalidFunc(map[string]string{"token_file": tokenPath}))
+	t.Run("Init() missing token_file", func(t *testing.T) {
+		err := NewInfluxDbConnector().Init(epoch, period, interval, map[string]string{
+			"url":        "fake-url-for-test",
+			"token_file": filepath.Join(t.TempDir(), "missing"),
+		})
+		assert.Error(t, err)
+	})
+}
+
+type mockWriteAPIBlocking struct {
+	mutex    sync.Mutex
+	failures int
+	attempts int
+	batches  []string
+}
+
+func (w *mockWriteAPIBlocking) getAttempts() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.attempts
+}
+
+func (w *mockWriteAPIBlocking) getBatches() []string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return append([]string(nil), w.batches...)
+}
+
+func (w *mockWriteAPIBlocking) WriteRecord(ctx context.Context, line ...string) error {
+	return nil
+}
+
+func (w *mockWriteAPIBlocking) WritePoint(ctx context.Context, point ...*write.Point) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.attempts++
+	if w.attempts <= w.failures {
+		return fmt.Errorf("write failed")
+	}
+
+	var batch strings.Builder
+	for _, p := range point {
+		batch.WriteString(p.Name())
+		for _, tag := range p.TagList() {
+			batch.WriteString(fmt.Sprintf(",%s=%s", tag.Key, tag.Value))
+		}
+		for i, field := range p.FieldList() {
+			separator := ","
+			if i == 0 {
+				separator = " "
+			}
+			batch.WriteString(fmt.Sprintf("%s%s=%v", separator, field.Key, field.Value))
+		}
+		batch.WriteString(fmt.Sprintf(" %d\n", p.Time().Unix()))
+	}
+	w.batches = append(w.batches, batch.String())
+
+	return nil
+}
 
 type mockQueryAPI struct {
 	queryRawFunc func(ctx context.Context, query string, dialect *domain.Dialect) (string, error)
@@ -93,7 +1052,7 @@ func TestInfluxDbConnectorQueries(t *testing.T) {
 		aggregateWindow(every: 2h0m0s, fn: mean, createEmpty: false)`,
 	}
 
-	t.Run("Read() set epoch", testQueriesFunc(defaultEpoch, 3*24*time.Hour, 2*time.Hour, setEpochExpectedQueries))
+	t.Run("Read() set epoch", testQueriesFunc(defaultEpoch, 3*24*time.Hour, 2*time.Hour, nil, setEpochExpectedQueries))
 
 	now = clockwork.NewFakeClockAt(time.Unix(1633421096, 0)).Now
 	zeroEpochExpectedQueries := []string{
@@ -102,14 +1061,383 @@ func TestInfluxDbConnectorQueries(t *testing.T) {
 		filter(fn: (r) => r["_measurement"] == "_measurement") |>
 		filter(fn: (r) => r["_field"] == "_value") |>
 		aggregateWindow(every: 1h0m0s, fn: mean, createEmpty: false)`,
+		// No _time returned yet, so the whole period is fetched again
 		`from(bucket:"") |>
-		range(start: 2021-10-05T07:04:56Z, stop: 2021-10-12T08:04:56Z) |>
+		range(start: 2021-09-28T08:04:56Z, stop: 2021-10-05T08:04:56Z) |>
 		filter(fn: (r) => r["_measurement"] == "_measurement") |>
 		filter(fn: (r) => r["_field"] == "_value") |>
 		aggregateWindow(every: 1h0m0s, fn: mean, createEmpty: false)`,
 	}
 
-	t.Run("Read() zero epoch", testQueriesFunc(time.Time{}, 7*24*time.Hour, time.Hour, zeroEpochExpectedQueries))
+	t.Run("Read() zero epoch", testQueriesFunc(time.Time{}, 7*24*time.Hour, time.Hour, nil, zeroEpochExpectedQueries))
+}
+
+func TestInfluxDbConnectorAggregates(t *testing.T) {
+	defaultEpoch := time.Unix(1625439896, 0)
+
+	sumExpectedQueries := []string{
+		`from(bucket:"") |>
+		range(start: 2021-07-04T23:04:56Z, stop: 2021-07-07T23:04:56Z) |>
+		filter(fn: (r) => r["_measurement"] == "_measurement") |>
+		filter(fn: (r) => r["_field"] == "_value") |>
+		aggregateWindow(every: 2h0m0s, fn: sum, createEmpty: true)`,
+	}
+
+	t.Run("Read() fn sum", testQueriesFunc(defaultEpoch, 3*24*time.Hour, 2*time.Hour, map[string]string{"fn": "sum", "create_empty": "true"}, sumExpectedQueries))
+
+	quantileExpectedQueries := []string{
+		`from(bucket:"") |>
+		range(start: 2021-07-04T23:04:56Z, stop: 2021-07-07T23:04:56Z) |>
+		filter(fn: (r) => r["_measurement"] == "_measurement") |>
+		filter(fn: (r) => r["_field"] == "_value") |>
+		aggregateWindow(every: 2h0m0s, fn: (column, tables=<-) => tables |> quantile(q: 0.95, column: column), createEmpty: false)`,
+	}
+
+	t.Run("Read() fn quantile", testQueriesFunc(defaultEpoch, 3*24*time.Hour, 2*time.Hour, map[string]string{"fn": "quantile", "quantile": "0.95"}, quantileExpectedQueries))
+
+	noneExpectedQueries := []string{
+		`from(bucket:"") |>
+		range(start: 2021-07-04T23:04:56Z, stop: 2021-07-07T23:04:56Z) |>
+		filter(fn: (r) => r["_measurement"] == "_measurement") |>
+		filter(fn: (r) => r["_field"] == "_value")`,
+	}
+
+	t.Run("Read() fn none", testQueriesFunc(defaultEpoch, 3*24*time.Hour, 2*time.Hour, map[string]string{"fn": "none"}, noneExpectedQueries))
+
+	invalidParams := []map[string]string{
+		{"fn": "average"},
+		{"fn": "quantile"},
+		{"fn": "quantile", "quantile": "1.5"},
+		{"create_empty": "maybe"},
+	}
+
+	for _, invalid := range invalidParams {
+		t.Run(fmt.Sprintf("Init() invalid %v", invalid), testInitInvalidFunc(invalid))
+	}
+}
+
+func TestInfluxDbConnectorQueryTemplate(t *testing.T) {
+	defaultEpoch := time.Unix(1625439896, 0)
+
+	queryTemplate := `from(bucket: "{{.Bucket}}") |>
+		range(start: {{.Start}}, stop: {{.Stop}}) |>
+		filter(fn: (r) => r["_measurement"] == "{{.Measurement}}" and r["host"] =~ /web-.*/) |>
+		aggregateWindow(every: {{.Interval}}, fn: sum, createEmpty: false) |>
+		pivot(rowKey: ["_time"], columnKey: ["_field"], valueColumn: "_value")`
+
+	expectedQueries := []string{
+		`from(bucket: "telegraf") |>
+		range(start: 2021-07-04T23:04:56Z, stop: 2021-07-07T23:04:56Z) |>
+		filter(fn: (r) => r["_measurement"] == "cpu" and r["host"] =~ /web-.*/) |>
+		aggregateWindow(every: 2h0m0s, fn: sum, createEmpty: false) |>
+		pivot(rowKey: ["_time"], columnKey: ["_field"], valueColumn: "_value")`,
+	}
+
+	templateParams := map[string]string{
+		"bucket":      "telegraf",
+		"measurement": "cpu",
+		"query":       queryTemplate,
+	}
+
+	t.Run("Read() query", testQueriesFunc(defaultEpoch, 3*24*time.Hour, 2*time.Hour, templateParams, expectedQueries))
+
+	queryFile := filepath.Join(t.TempDir(), "query.flux")
+	err := os.WriteFile(queryFile, []byte(queryTemplate), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	templateFileParams := map[string]string{
+		"bucket":      "telegraf",
+		"measurement": "cpu",
+		"query_file":  queryFile,
+	}
+
+	t.Run("Read() query_file", testQueriesFunc(defaultEpoch, 3*24*time.Hour, 2*time.Hour, templateFileParams, expectedQueries))
+
+	invalidParams := []map[string]string{
+		{"query": "from(bucket: {{.Bucket}"},
+		{"query": "from(bucket: {{.Unknown}})"},
+		{"query": "from(bucket: {{.Bucket}})", "query_file": queryFile},
+		{"query_file": filepath.Join(t.TempDir(), "does-not-exist.flux")},
+	}
+
+	for _, invalid := range invalidParams {
+		t.Run(fmt.Sprintf("Init() invalid %v", invalid), testInitInvalidFunc(invalid))
+	}
+}
+
+func TestInfluxDbConnectorFilters(t *testing.T) {
+	defaultEpoch := time.Unix(1625439896, 0)
+
+	filterParams := map[string]string{
+		"bucket":      "telegraf",
+		"measurement": "cpu",
+		"field":       "usage_idle, usage_user, usage_system",
+		"tags":        "host=web-*,host=db-1,cpu=cpu-total",
+	}
+
+	expectedQueries := []string{
+		`from(bucket:"telegraf") |>
+		range(start: 2021-07-04T23:04:56Z, stop: 2021-07-07T23:04:56Z) |>
+		filter(fn: (r) => r["_measurement"] == "cpu") |>
+		filter(fn: (r) => (r["_field"] == "usage_idle" or r["_field"] == "usage_user" or r["_field"] == "usage_system")) |>
+		filter(fn: (r) => (r["host"] =~ /^web-.*$/ or r["host"] == "db-1") and r["cpu"] == "cpu-total") |>
+		aggregateWindow(every: 2h0m0s, fn: mean, createEmpty: false)`,
+	}
+
+	t.Run("Read() multiple fields and tags", testQueriesFunc(defaultEpoch, 3*24*time.Hour, 2*time.Hour, filterParams, expectedQueries))
+
+	measurementsParams := map[string]string{
+		"measurement": "cpu,mem",
+		"field":       "usage_idle",
+		"query":       `from(bucket: "{{.Bucket}}") |> range(start: {{.Start}}, stop: {{.Stop}}) |> {{.Filters}}`,
+	}
+
+	measurementsExpectedQueries := []string{
+		`from(bucket: "") |> range(start: 2021-07-04T23:04:56Z, stop: 2021-07-07T23:04:56Z) |> filter(fn: (r) => (r["_measurement"] == "cpu" or r["_measurement"] == "mem")) |>
+		filter(fn: (r) => r["_field"] == "usage_idle")`,
+	}
+
+	t.Run("Read() multiple measurements in query template", testQueriesFunc(defaultEpoch, 3*24*time.Hour, 2*time.Hour, measurementsParams, measurementsExpectedQueries))
+
+	t.Run("Init() invalid tags", testInitInvalidFunc(map[string]string{"tags": "host"}))
+}
+
+func TestInfluxDbConnectorInfluxQL(t *testing.T) {
+	response := `{"results":[{"statement_id":0,"series":[
+		{"name":"cpu","tags":{"host":"web-1"},"columns":["time","usage_idle","usage_user"],"values":[
+			["2021-07-04T23:00:00Z",99.5,0.25],
+			["2021-07-05T01:00:00Z",98,null]
+		]},
+		{"name":"cpu","tags":{"host":"web-2"},"columns":["time","usage_idle","usage_user"],"values":[
+			["2021-07-04T23:00:00Z",97,2]
+		]}
+	]}]}`
+
+	var receivedQuery string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != "spice" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"authorization failed"}`))
+			return
+		}
+
+		assert.Equal(t, "/query", r.URL.Path)
+		assert.Equal(t, "telegraf", r.URL.Query().Get("db"))
+		assert.Equal(t, "autogen", r.URL.Query().Get("rp"))
+		receivedQuery = r.URL.Query().Get("q")
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(response))
+	}))
+	defer server.Close()
+
+	params := map[string]string{
+		"version":          "1",
+		"url":              server.URL,
+		"username":         "spice",
+		"password":         "secret",
+		"database":         "telegraf/autogen",
+		"measurement":      "cpu",
+		"field":            "usage_idle,usage_user",
+		"fn":               "max",
+		"tags":             "host=web-*",
+		"refresh_interval": "0",
+	}
+
+	c := NewInfluxDbConnector()
+
+	var readData []byte
+	err := c.Read(func(data []byte, metadata map[string]string) ([]byte, error) {
+		readData = data
+		return nil, nil
+	})
+	assert.NoError(t, err)
+
+	err = c.Init(time.Unix(1625439600, 0), 3*time.Hour, time.Hour, params)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, `SELECT MAX("usage_idle") AS "usage_idle", MAX("usage_user") AS "usage_user" FROM "cpu" WHERE time >= '2021-07-04T23:00:00Z' AND time < '2021-07-05T02:00:00Z' AND "host" =~ /^web-.*$/ GROUP BY time(1h), * fill(none)`, receivedQuery)
+
+	processor := flux.NewFluxCsvProcessor()
+	_, err = processor.OnData(readData)
+	assert.NoError(t, err)
+
+	observations, err := processor.GetObservations()
+	if assert.NoError(t, err) {
+		assert.Len(t, observations, 5)
+		assert.Equal(t, int64(1625439600), observations[0].Time)
+		assert.Equal(t, map[string]float64{"usage_idle": 99.5}, observations[0].Data)
+		assert.Equal(t, []string{"web-1"}, observations[0].Tags)
+	}
+
+	t.Run("Init() invalid credentials", func(t *testing.T) {
+		invalidParams := map[string]string{}
+		for key, value := range params {
+			invalidParams[key] = value
+		}
+		invalidParams["password"] = "wrong"
+
+		err := NewInfluxDbConnector().Init(time.Unix(1625439600, 0), 3*time.Hour, time.Hour, invalidParams)
+		assert.Error(t, err)
+	})
+
+	t.Run("Init() missing database", testInitInvalidFunc(map[string]string{"version": "1", "measurement": "cpu", "field": "usage_idle"}))
+}
+
+func TestInfluxDbConnectorBackfill(t *testing.T) {
+	epoch := time.Unix(1625439896, 0)
+	period := 3 * 24 * time.Hour
+	interval := time.Hour
+
+	checkpointPath := filepath.Join(t.TempDir(), "backfill.json")
+
+	params := map[string]string{
+		"url":                    "fake-url-for-test",
+		"token":                  "fake-token-for-test",
+		"refresh_interval":       "0",
+		"retry_initial_interval": "10ms",
+		"retry_jitter":           "0",
+		"backfill_chunk":         "24h",
+		"backfill_checkpoint":    checkpointPath,
+	}
+
+	type backfillResult struct {
+		mutex          sync.Mutex
+		queriedStarts  []string
+		readMetadata   []map[string]string
+		failedAttempts int
+	}
+
+	newConnector := func(failStart string) (*InfluxDbConnector, *backfillResult) {
+		c := NewInfluxDbConnector()
+		result := &backfillResult{}
+
+		mockQueryAPI := &mockQueryAPI{}
+		mockQueryAPI.setQueryRaw(func(ctx context.Context, query string, dialect *domain.Dialect) (string, error) {
+			result.mutex.Lock()
+			defer result.mutex.Unlock()
+
+			// Fails twice, then succeeds
+			if failStart != "" && strings.Contains(query, "start: "+failStart) && result.failedAttempts < 2 {
+				result.failedAttempts++
+				return "", fmt.Errorf("query timed out")
+			}
+			start := query[strings.Index(query, "start: ")+7:]
+			result.queriedStarts = append(result.queriedStarts, start[:strings.Index(start, ",")])
+			return "query-result", nil
+		})
+		c.SetInfluxdbClient(&mockClient{
+			queryAPIFunc: func(org string) api.QueryAPI {
+				return mockQueryAPI
+			},
+		})
+
+		err := c.Read(func(data []byte, metadata map[string]string) ([]byte, error) {
+			result.mutex.Lock()
+			defer result.mutex.Unlock()
+			result.readMetadata = append(result.readMetadata, metadata)
+			return nil, nil
+		})
+		assert.NoError(t, err)
+
+		return c, result
+	}
+
+	waitForBackfill := func(c *InfluxDbConnector) {
+		assert.Eventually(t, func() bool {
+			status := c.Status()
+			return status.State == StatusOk && !status.LastSuccessTime.IsZero()
+		}, time.Second, 5*time.Millisecond)
+	}
+
+	// The second chunk fails twice. Init doesn't wait for the backfill, which retries the failed chunk
+	// without fetching the delivered one again. Chunks after the first start on whole days, so no
+	// hourly window is split between chunks.
+	c, result := newConnector("2021-07-05T00:00:00Z")
+	err := c.Init(epoch, period, interval, params)
+	assert.NoError(t, err)
+	waitForBackfill(c)
+
+	result.mutex.Lock()
+	assert.Equal(t, 2, result.failedAttempts)
+	assert.Equal(t, []string{"2021-07-04T23:04:56Z", "2021-07-05T00:00:00Z", "2021-07-06T00:00:00Z", "2021-07-07T00:00:00Z"}, result.queriedStarts)
+	if assert.Len(t, result.readMetadata, 4) {
+		assert.Equal(t, "1", result.readMetadata[0]["chunk"])
+		assert.Equal(t, "4", result.readMetadata[0]["chunks"])
+		assert.Equal(t, "2021-07-05T00:00:00Z", result.readMetadata[0]["end"])
+		assert.Equal(t, "4", result.readMetadata[3]["chunk"])
+		assert.Equal(t, "2021-07-07T23:04:56Z", result.readMetadata[3]["end"])
+	}
+	result.mutex.Unlock()
+
+	// A new connector resumes from the checkpoint and finds nothing left to fetch
+	c, result = newConnector("")
+	err = c.Init(epoch, period, interval, params)
+	assert.NoError(t, err)
+	waitForBackfill(c)
+	result.mutex.Lock()
+	assert.Len(t, result.queriedStarts, 0)
+	result.mutex.Unlock()
+
+	// A different window ignores the checkpoint
+	c, result = newConnector("")
+	err = c.Init(epoch, 2*24*time.Hour, interval, params)
+	assert.NoError(t, err)
+	waitForBackfill(c)
+	result.mutex.Lock()
+	assert.Len(t, result.queriedStarts, 3)
+	result.mutex.Unlock()
+
+	t.Run("Init() resumes an interrupted backfill from the checkpoint", func(t *testing.T) {
+		resumeParams := map[string]string{
+			"backfill_checkpoint": filepath.Join(t.TempDir(), "backfill.json"),
+			"retry_max_attempts":  "1",
+		}
+		for key, value := range params {
+			if _, ok := resumeParams[key]; !ok {
+				resumeParams[key] = value
+			}
+		}
+
+		// Stops at the failed third chunk after delivering the first two
+		c, result := newConnector("2021-07-06T00:00:00Z")
+		err := c.Init(epoch, period, interval, resumeParams)
+		assert.NoError(t, err)
+		assert.Eventually(t, func() bool {
+			return c.Status().State == StatusStopped
+		}, time.Second, 5*time.Millisecond)
+		result.mutex.Lock()
+		assert.Len(t, result.readMetadata, 2)
+		result.mutex.Unlock()
+
+		// A restarted connector only fetches the remaining chunks
+		c, result = newConnector("")
+		err = c.Init(epoch, period, interval, resumeParams)
+		assert.NoError(t, err)
+		waitForBackfill(c)
+		result.mutex.Lock()
+		assert.Equal(t, []string{"2021-07-06T00:00:00Z", "2021-07-07T00:00:00Z"}, result.queriedStarts)
+		result.mutex.Unlock()
+	})
+
+	t.Run("Init() invalid backfill_chunk", testInitInvalidFunc(map[string]string{"backfill_chunk": "daily"}))
+
+	t.Run("Init() backfill_chunk not a multiple of interval", func(t *testing.T) {
+		chunkParams := map[string]string{"backfill_chunk": "90s"}
+		for key, value := range params {
+			if key != "backfill_chunk" {
+				chunkParams[key] = value
+			}
+		}
+
+		err := NewInfluxDbConnector().Init(epoch, period, time.Minute, chunkParams)
+		assert.EqualError(t, err, "invalid backfill_chunk '90s': chunk must be a multiple of the interval 1m0s")
+	})
 }
 
 func testInitFunc(params map[string]string) func(*testing.T) {
@@ -125,6 +1453,27 @@ func testInitFunc(params map[string]string) func(*testing.T) {
 	}
 }
 
+func testInitInvalidFunc(extraParams map[string]string) func(*testing.T) {
+	c := NewInfluxDbConnector()
+
+	return func(t *testing.T) {
+		params := map[string]string{
+			"url":   "fake-url-for-test",
+			"token": "fake-token-for-test",
+		}
+		for key, value := range extraParams {
+			params[key] = value
+		}
+
+		var epoch time.Time
+		var period time.Duration
+		var interval time.Duration
+
+		err := c.Init(epoch, period, interval, params)
+		assert.Error(t, err)
+	}
+}
+
 func testReadFunc(params map[string]string) func(*testing.T) {
 	c := NewInfluxDbConnector()
 
@@ -163,12 +1512,15 @@ func testReadFunc(params map[string]string) func(*testing.T) {
 	}
 }
 
-func testQueriesFunc(epoch time.Time, period time.Duration, interval time.Duration, expectedQueries []string) func(*testing.T) {
+func testQueriesFunc(epoch time.Time, period time.Duration, interval time.Duration, extraParams map[string]string, expectedQueries []string) func(*testing.T) {
 	params := map[string]string{
 		"url":              "fake-url-for-test",
 		"token":            "fake-token-for-test",
 		"refresh_interval": "250ms",
 	}
+	for key, value := range extraParams {
+		params[key] = value
+	}
 
 	c := NewInfluxDbConnector()
 
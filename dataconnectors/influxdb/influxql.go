@@ -0,0 +1,338 @@
+package influxdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Selects the InfluxDB 1.x InfluxQL API instead of Flux
+	influxQLVersion string = "1"
+
+	defaultInfluxQLTimeout = 30 * time.Second
+)
+
+// Queries the InfluxDB 1.x /query endpoint with basic credentials
+type influxQLClient struct {
+	url             string
+	database        string
+	retentionPolicy string
+	username        string
+	password        string
+	httpClient      *http.Client
+}
+
+type influxQLResponse struct {
+	Results []influxQLResult `json:"results"`
+	Error   string           `json:"error"`
+}
+
+type influxQLResult struct {
+	StatementID int              `json:"statement_id"`
+	Series      []influxQLSeries `json:"series"`
+	Error       string           `json:"error"`
+}
+
+type influxQLSeries struct {
+	Name    string            `json:"name"`
+	Tags    map[string]string `json:"tags"`
+	Columns []string          `json:"columns"`
+	Values  [][]interface{}   `json:"values"`
+}
+
+func newInfluxQLClient(params map[string]string, httpClient *http.Client) (*influxQLClient, error) {
+	database := params["database"]
+	if database == "" {
+		// InfluxDB 2.x compatible bucket naming is database/retention-policy
+		database = params["bucket"]
+	}
+	if database == "" {
+		return nil, errors.New("influxdb connector requires the 'database' parameter to be set for version 1")
+	}
+
+	retentionPolicy := params["retention_policy"]
+	if slashIndex := strings.Index(database, "/"); slashIndex != -1 {
+		retentionPolicy = database[slashIndex+1:]
+		database = database[:slashIndex]
+	}
+
+	return &influxQLClient{
+		url:             params["url"],
+		database:        database,
+		retentionPolicy: retentionPolicy,
+		username:        params["username"],
+		password:        params["password"],
+		httpClient:      httpClient,
+	}, nil
+}
+
+func (q *influxQLClient) query(ctx context.Context, query string) (*influxQLResponse, error) {
+	endpoint, err := url.Parse(q.url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url '%s': %w", q.url, err)
+	}
+	endpoint.Path = path.Join(endpoint.Path, "query")
+
+	values := url.Values{}
+	values.Set("db", q.database)
+	if q.retentionPolicy != "" {
+		values.Set("rp", q.retentionPolicy)
+	}
+	values.Set("q", query)
+	endpoint.RawQuery = values.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if q.username != "" {
+		req.SetBasicAuth(q.username, q.password)
+	}
+
+	resp, err := q.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &influxQLResponse{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	decodeErr := decoder.Decode(response)
+
+	if resp.StatusCode != http.StatusOK {
+		if decodeErr == nil && response.Error != "" {
+			return nil, fmt.Errorf("influxql query failed: %s: %s", resp.Status, response.Error)
+		}
+		return nil, fmt.Errorf("influxql query failed: %s", resp.Status)
+	}
+
+	if decodeErr != nil {
+		return nil, fmt.Errorf("failed to decode influxql response: %w", decodeErr)
+	}
+
+	if response.Error != "" {
+		return nil, fmt.Errorf("influxql query failed: %s", response.Error)
+	}
+
+	for _, result := range response.Results {
+		if result.Error != "" {
+			return nil, fmt.Errorf("influxql query failed: %s", result.Error)
+		}
+	}
+
+	return response, nil
+}
+
+func (c *InfluxDbConnector) buildInfluxQLQuery(periodStart string, periodEnd string, interval time.Duration) (string, error) {
+	conditions := c.buildInfluxQLConditions()
+
+	if c.queryTemplate != nil {
+		return c.executeQueryTemplate(periodStart, periodEnd, interval, strings.Join(conditions, " AND "))
+	}
+
+	selectors := make([]string, len(c.fields))
+	for i, field := range c.fields {
+		switch c.fn {
+		case fnNone:
+			selectors[i] = influxQLIdentifier(field)
+		case fnQuantile:
+			selectors[i] = fmt.Sprintf("PERCENTILE(%s, %s) AS %s", influxQLIdentifier(field), strconv.FormatFloat(c.quantile*100, 'f', -1, 64), influxQLIdentifier(field))
+		default:
+			selectors[i] = fmt.Sprintf("%s(%s) AS %s", strings.ToUpper(c.fn), influxQLIdentifier(field), influxQLIdentifier(field))
+		}
+	}
+
+	measurements := make([]string, len(c.measurements))
+	for i, measurement := range c.measurements {
+		measurements[i] = influxQLIdentifier(measurement)
+	}
+
+	where := []string{
+		fmt.Sprintf("time >= %s", influxQLString(periodStart)),
+		fmt.Sprintf("time < %s", influxQLString(periodEnd)),
+	}
+	where = append(where, conditions...)
+
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s", strings.Join(selectors, ", "), strings.Join(measurements, ", "), strings.Join(where, " AND "))
+
+	if c.fn == fnNone {
+		return query + " GROUP BY *", nil
+	}
+
+	fill := "none"
+	if c.createEmpty {
+		fill = "null"
+	}
+
+	return fmt.Sprintf("%s GROUP BY time(%s), * fill(%s)", query, influxQLDuration(interval), fill), nil
+}
+
+func (c *InfluxDbConnector) buildInfluxQLConditions() []string {
+	conditions := make([]string, len(c.tagFilters))
+	for i, tagFilter := range c.tagFilters {
+		predicates := make([]string, len(tagFilter.values))
+		for j, value := range tagFilter.values {
+			if strings.Contains(value, "*") {
+				predicates[j] = fmt.Sprintf("%s =~ %s", influxQLIdentifier(tagFilter.key), wildcardRegex(value))
+			} else {
+				predicates[j] = fmt.Sprintf("%s = %s", influxQLIdentifier(tagFilter.key), influxQLString(value))
+			}
+		}
+
+		condition := strings.Join(predicates, " OR ")
+		if len(predicates) > 1 {
+			condition = "(" + condition + ")"
+		}
+		conditions[i] = condition
+	}
+
+	return conditions
+}
+
+func (c *InfluxDbConnector) queryInfluxQL(periodStart string, periodEnd string, interval time.Duration) ([]byte, error) {
+	query, err := c.buildInfluxQLQuery(periodStart, periodEnd, interval)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := c.influxQL.query(context.Background(), query)
+	if err != nil {
+		return nil, err
+	}
+
+	return influxQLToAnnotatedCsv(response, periodStart, periodEnd)
+}
+
+// Converts an InfluxQL JSON response into Flux annotated CSV, with one table per series and field,
+// so it can be consumed by the flux-csv processor
+func influxQLToAnnotatedCsv(response *influxQLResponse, periodStart string, periodEnd string) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+
+	table := 0
+	for _, result := range response.Results {
+		for _, series := range result.Series {
+			if len(series.Columns) == 0 || series.Columns[0] != "time" {
+				return nil, fmt.Errorf("influxql series '%s' is missing the time column", series.Name)
+			}
+
+			tagKeys := make([]string, 0, len(series.Tags))
+			for tagKey := range series.Tags {
+				tagKeys = append(tagKeys, tagKey)
+			}
+			sort.Strings(tagKeys)
+
+			for col := 1; col < len(series.Columns); col++ {
+				if table > 0 {
+					// Tables with a different schema are separated by an empty line
+					writer.Flush()
+					buffer.WriteString("\n")
+				}
+
+				group := []string{"#group", "false", "false", "true", "true", "false", "false", "true", "true"}
+				datatype := []string{"#datatype", "string", "long", "dateTime:RFC3339", "dateTime:RFC3339", "dateTime:RFC3339", "double", "string", "string"}
+				defaults := []string{"#default", "_result", "", "", "", "", "", "", ""}
+				header := []string{"", "result", "table", "_start", "_stop", "_time", "_value", "_field", "_measurement"}
+				for _, tagKey := range tagKeys {
+					group = append(group, "true")
+					datatype = append(datatype, "string")
+					defaults = append(defaults, "")
+					header = append(header, tagKey)
+				}
+
+				for _, record := range [][]string{group, datatype, defaults, header} {
+					if err := writer.Write(record); err != nil {
+						return nil, err
+					}
+				}
+
+				for _, row := range series.Values {
+					if len(row) <= col || row[col] == nil {
+						continue
+					}
+
+					value, ok := influxQLFloat(row[col])
+					if !ok {
+						continue
+					}
+
+					record := []string{"", "", strconv.Itoa(table), periodStart, periodEnd, fmt.Sprintf("%v", row[0]), strconv.FormatFloat(value, 'f', -1, 64), series.Columns[col], series.Name}
+					for _, tagKey := range tagKeys {
+						record = append(record, series.Tags[tagKey])
+					}
+
+					if err := writer.Write(record); err != nil {
+						return nil, err
+					}
+				}
+
+				table++
+			}
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func influxQLFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func influxQLIdentifier(identifier string) string {
+	return `"` + strings.ReplaceAll(strings.ReplaceAll(identifier, `\`, `\\`), `"`, `\"`) + `"`
+}
+
+func influxQLString(value string) string {
+	return `'` + strings.ReplaceAll(strings.ReplaceAll(value, `\`, `\\`), `'`, `\'`) + `'`
+}
+
+// Formats an interval as an InfluxQL duration literal, which only allows a single unit
+func influxQLDuration(interval time.Duration) string {
+	switch {
+	case interval%time.Hour == 0:
+		return fmt.Sprintf("%dh", interval/time.Hour)
+	case interval%time.Minute == 0:
+		return fmt.Sprintf("%dm", interval/time.Minute)
+	case interval%time.Second == 0:
+		return fmt.Sprintf("%ds", interval/time.Second)
+	case interval%time.Millisecond == 0:
+		return fmt.Sprintf("%dms", interval/time.Millisecond)
+	}
+	return fmt.Sprintf("%du", interval/time.Microsecond)
+}
@@ -0,0 +1,173 @@
+package influxdb
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	fnNone     string = "none"
+	fnQuantile string = "quantile"
+)
+
+var (
+	// Flux aggregate functions supported by aggregateWindow()
+	validAggregateFns = map[string]bool{
+		"mean":     true,
+		"median":   true,
+		"min":      true,
+		"max":      true,
+		"sum":      true,
+		"count":    true,
+		"first":    true,
+		"last":     true,
+		"spread":   true,
+		"stddev":   true,
+		fnQuantile: true,
+		fnNone:     true,
+	}
+)
+
+func parseAggregateParams(params map[string]string) (string, float64, bool, error) {
+	// Default to "mean"
+	fn := "mean"
+	if paramFn, ok := params["fn"]; ok {
+		fn = paramFn
+	}
+
+	if !validAggregateFns[fn] {
+		return "", 0, false, fmt.Errorf("invalid fn '%s'", fn)
+	}
+
+	var quantile float64
+	if fn == fnQuantile {
+		q, ok := params["quantile"]
+		if !ok {
+			return "", 0, false, fmt.Errorf("fn '%s' requires the 'quantile' parameter to be set", fnQuantile)
+		}
+		var err error
+		quantile, err = strconv.ParseFloat(q, 64)
+		if err != nil {
+			return "", 0, false, fmt.Errorf("invalid quantile '%s': %s", q, err)
+		}
+		if quantile < 0 || quantile > 1 {
+			return "", 0, false, fmt.Errorf("invalid quantile '%s': quantile must be between 0 and 1", q)
+		}
+	}
+
+	createEmpty := false
+	if paramCreateEmpty, ok := params["create_empty"]; ok {
+		ce, err := strconv.ParseBool(paramCreateEmpty)
+		if err != nil {
+			return "", 0, false, fmt.Errorf("invalid create_empty '%s': %s", paramCreateEmpty, err)
+		}
+		createEmpty = ce
+	}
+
+	return fn, quantile, createEmpty, nil
+}
+
+// Values available to custom query templates, e.g. range(start: {{.Start}}, stop: {{.Stop}})
+type queryTemplateData struct {
+	Start       string
+	Stop        string
+	Interval    string
+	Bucket      string
+	Measurement string
+	Field       string
+	// Measurement, field and tag filter() stages built from the params,
+	// or the tag conditions of the WHERE clause for InfluxQL
+	Filters string
+}
+
+// Parses the Flux query template from the 'query' or 'query_file' params, if set
+func parseQueryTemplate(params map[string]string) (*template.Template, error) {
+	queryText, hasQuery := params["query"]
+	queryFile, hasQueryFile := params["query_file"]
+
+	if hasQuery && hasQueryFile {
+		return nil, fmt.Errorf("only one of 'query' and 'query_file' may be set")
+	}
+
+	if hasQueryFile {
+		if !filepath.IsAbs(queryFile) {
+			queryFile = filepath.Join(params["appDirectory"], queryFile)
+		}
+		queryData, err := os.ReadFile(queryFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read query_file '%s': %w", queryFile, err)
+		}
+		queryText = string(queryData)
+	} else if !hasQuery {
+		return nil, nil
+	}
+
+	queryTemplate, err := template.New("query").Parse(queryText)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query template: %w", err)
+	}
+
+	// Execute once so unknown placeholders fail at Init rather than on refresh
+	err = queryTemplate.Execute(&bytes.Buffer{}, &queryTemplateData{})
+	if err != nil {
+		return nil, fmt.Errorf("invalid query template: %w", err)
+	}
+
+	return queryTemplate, nil
+}
+
+func (c *InfluxDbConnector) executeQueryTemplate(periodStart string, periodEnd string, interval time.Duration, filters string) (string, error) {
+	data := &queryTemplateData{
+		Start:       periodStart,
+		Stop:        periodEnd,
+		Interval:    interval.String(),
+		Bucket:      c.bucket,
+		Measurement: strings.Join(c.measurements, ","),
+		Field:       strings.Join(c.fields, ","),
+		Filters:     filters,
+	}
+
+	var query bytes.Buffer
+	err := c.queryTemplate.Execute(&query, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute query template: %w", err)
+	}
+
+	return query.String(), nil
+}
+
+func (c *InfluxDbConnector) buildQuery(periodStart string, periodEnd string, interval time.Duration) (string, error) {
+	if c.queryTemplate != nil {
+		return c.executeQueryTemplate(periodStart, periodEnd, interval, strings.Join(c.buildFilters(), " |>\n"))
+	}
+
+	stages := []string{
+		fmt.Sprintf(`from(bucket:"%s")`, c.bucket),
+		fmt.Sprintf(`range(start: %s, stop: %s)`, periodStart, periodEnd),
+	}
+	stages = append(stages, c.buildFilters()...)
+
+	if aggregate := c.aggregateWindow(interval); aggregate != "" {
+		stages = append(stages, aggregate)
+	}
+
+	return strings.Join(stages, " |>\n"), nil
+}
+
+func (c *InfluxDbConnector) aggregateWindow(interval time.Duration) string {
+	switch c.fn {
+	case fnNone:
+		return ""
+	case fnQuantile:
+		fn := fmt.Sprintf("(column, tables=<-) => tables |> quantile(q: %s, column: column)", strconv.FormatFloat(c.quantile, 'f', -1, 64))
+		return fmt.Sprintf("aggregateWindow(every: %s, fn: %s, createEmpty: %t)", interval.String(), fn, c.createEmpty)
+	}
+
+	return fmt.Sprintf("aggregateWindow(every: %s, fn: %s, createEmpty: %t)", interval.String(), c.fn, c.createEmpty)
+}
@@ -0,0 +1,125 @@
+package influxdb
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+const (
+	StatusOk       string = "ok"
+	StatusRetrying string = "retrying"
+	StatusStopped  string = "stopped"
+)
+
+// ConnectorStatus reports the health of the connector's refresher
+type ConnectorStatus struct {
+	State               string
+	ConsecutiveFailures int
+	LastError           string
+	LastErrorTime       time.Time
+	LastSuccessTime     time.Time
+	NextRetryTime       time.Time
+}
+
+// Exponential backoff with jitter between failed refreshes
+type retryPolicy struct {
+	initialInterval time.Duration
+	maxInterval     time.Duration
+	multiplier      float64
+	jitter          float64
+	// 0 retries forever
+	maxAttempts int
+}
+
+func defaultRetryPolicy() *retryPolicy {
+	return &retryPolicy{
+		initialInterval: time.Second,
+		maxInterval:     5 * time.Minute,
+		multiplier:      2,
+		jitter:          0.2,
+	}
+}
+
+func parseRetryPolicy(params map[string]string) (*retryPolicy, error) {
+	policy := defaultRetryPolicy()
+
+	if initialInterval, ok := params["retry_initial_interval"]; ok {
+		ii, err := time.ParseDuration(initialInterval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retry_initial_interval '%s': %s", initialInterval, err)
+		}
+		if ii <= 0 {
+			return nil, fmt.Errorf("invalid retry_initial_interval '%s': interval must be > 0", initialInterval)
+		}
+		policy.initialInterval = ii
+	}
+
+	if maxInterval, ok := params["retry_max_interval"]; ok {
+		mi, err := time.ParseDuration(maxInterval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retry_max_interval '%s': %s", maxInterval, err)
+		}
+		if mi < policy.initialInterval {
+			return nil, fmt.Errorf("invalid retry_max_interval '%s': interval must be >= retry_initial_interval", maxInterval)
+		}
+		policy.maxInterval = mi
+	}
+
+	if multiplier, ok := params["retry_multiplier"]; ok {
+		m, err := strconv.ParseFloat(multiplier, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retry_multiplier '%s': %s", multiplier, err)
+		}
+		if m < 1 {
+			return nil, fmt.Errorf("invalid retry_multiplier '%s': multiplier must be >= 1", multiplier)
+		}
+		policy.multiplier = m
+	}
+
+	if jitter, ok := params["retry_jitter"]; ok {
+		j, err := strconv.ParseFloat(jitter, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retry_jitter '%s': %s", jitter, err)
+		}
+		if j < 0 || j > 1 {
+			return nil, fmt.Errorf("invalid retry_jitter '%s': jitter must be between 0 and 1", jitter)
+		}
+		policy.jitter = j
+	}
+
+	if maxAttempts, ok := params["retry_max_attempts"]; ok {
+		ma, err := strconv.Atoi(maxAttempts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retry_max_attempts '%s': %s", maxAttempts, err)
+		}
+		if ma < 0 {
+			return nil, fmt.Errorf("invalid retry_max_attempts '%s': attempts must be >= 0", maxAttempts)
+		}
+		policy.maxAttempts = ma
+	}
+
+	return policy, nil
+}
+
+// Returns whether another attempt is allowed after the given number of consecutive failures
+func (p *retryPolicy) shouldRetry(failures int) bool {
+	return p.maxAttempts == 0 || failures < p.maxAttempts
+}
+
+// Returns the delay before retrying after the given number of consecutive failures
+func (p *retryPolicy) delay(failures int) time.Duration {
+	backoff := float64(p.initialInterval) * math.Pow(p.multiplier, float64(failures-1))
+	if backoff > float64(p.maxInterval) {
+		backoff = float64(p.maxInterval)
+	}
+
+	if p.jitter > 0 {
+		// Spread retries across [backoff * (1 - jitter), backoff * (1 + jitter)]
+		backoff *= 1 + p.jitter*(2*rand.Float64()-1)
+	}
+
+	return time.Duration(backoff)
+}
@@ -0,0 +1,323 @@
+package influxdb
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	// Flux annotated CSV datatypes to InfluxDB field types
+	fieldTypes = map[string]string{
+		"double":       "float",
+		"long":         "integer",
+		"unsignedLong": "unsigned",
+		"string":       "string",
+		"boolean":      "boolean",
+	}
+)
+
+type FieldSchema struct {
+	Name string
+	// float, integer, unsigned, string or boolean
+	Type string
+}
+
+type MeasurementSchema struct {
+	Name      string
+	Fields    []*FieldSchema
+	TagKeys   []string
+	TagValues map[string][]string
+}
+
+// A table of an annotated CSV result, with rows keyed by column
+type annotatedTable struct {
+	datatypes map[string]string
+	rows      []map[string]string
+}
+
+// ListBuckets lists the buckets visible to the connector's token
+func (c *InfluxDbConnector) ListBuckets() ([]string, error) {
+	return c.querySchemaValues(`buckets() |> keep(columns: ["name"])`, "name")
+}
+
+// ListMeasurements lists the measurements written to bucket between start and stop.
+// An empty bucket uses the connector's bucket.
+func (c *InfluxDbConnector) ListMeasurements(bucket string, start time.Time, stop time.Time) ([]string, error) {
+	query := fmt.Sprintf(`import "influxdata/influxdb/schema"
+schema.measurements(bucket: %s, start: %s, stop: %s)`, fluxString(c.schemaBucket(bucket)), fluxTime(start), fluxTime(stop))
+
+	return c.querySchemaValues(query, "_value")
+}
+
+// ListFields lists the fields of measurement with their types
+func (c *InfluxDbConnector) ListFields(bucket string, measurement string, start time.Time, stop time.Time) ([]*FieldSchema, error) {
+	// One table per field keeps the _value datatype of each field
+	query := strings.Join([]string{
+		fmt.Sprintf(`from(bucket: %s)`, fluxString(c.schemaBucket(bucket))),
+		fmt.Sprintf(`range(start: %s, stop: %s)`, fluxTime(start), fluxTime(stop)),
+		fmt.Sprintf(`filter(fn: (r) => %s)`, columnPredicate("_measurement", []string{measurement})),
+		`group(columns: ["_field"])`,
+		`first()`,
+		`keep(columns: ["_field", "_value"])`,
+	}, " |>\n")
+
+	tables, err := c.querySchemaTables(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []*FieldSchema
+	for _, table := range tables {
+		for _, row := range table.rows {
+			fieldType, ok := fieldTypes[table.datatypes["_value"]]
+			if !ok {
+				fieldType = table.datatypes["_value"]
+			}
+			fields = append(fields, &FieldSchema{Name: row["_field"], Type: fieldType})
+		}
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Name < fields[j].Name
+	})
+
+	return fields, nil
+}
+
+// ListTagKeys lists the tag keys of measurement
+func (c *InfluxDbConnector) ListTagKeys(bucket string, measurement string, start time.Time, stop time.Time) ([]string, error) {
+	query := fmt.Sprintf(`import "influxdata/influxdb/schema"
+schema.tagKeys(bucket: %s, predicate: (r) => %s, start: %s, stop: %s)`,
+		fluxString(c.schemaBucket(bucket)), columnPredicate("_measurement", []string{measurement}), fluxTime(start), fluxTime(stop))
+
+	keys, err := c.querySchemaValues(query, "_value")
+	if err != nil {
+		return nil, err
+	}
+
+	// Drop _start, _stop, _measurement and _field
+	tagKeys := []string{}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, "_") {
+			tagKeys = append(tagKeys, key)
+		}
+	}
+
+	return tagKeys, nil
+}
+
+// ListTagValues lists the values of tag key within measurement
+func (c *InfluxDbConnector) ListTagValues(bucket string, measurement string, key string, start time.Time, stop time.Time) ([]string, error) {
+	query := fmt.Sprintf(`import "influxdata/influxdb/schema"
+schema.tagValues(bucket: %s, tag: %s, predicate: (r) => %s, start: %s, stop: %s)`,
+		fluxString(c.schemaBucket(bucket)), fluxString(key), columnPredicate("_measurement", []string{measurement}), fluxTime(start), fluxTime(stop))
+
+	return c.querySchemaValues(query, "_value")
+}
+
+// DiscoverSchema describes every measurement in bucket between start and stop
+func (c *InfluxDbConnector) DiscoverSchema(bucket string, start time.Time, stop time.Time) ([]*MeasurementSchema, error) {
+	measurements, err := c.ListMeasurements(bucket, start, stop)
+	if err != nil {
+		return nil, err
+	}
+
+	schemas := make([]*MeasurementSchema, len(measurements))
+	for i, measurement := range measurements {
+		fields, err := c.ListFields(bucket, measurement, start, stop)
+		if err != nil {
+			return nil, err
+		}
+
+		tagKeys, err := c.ListTagKeys(bucket, measurement, start, stop)
+		if err != nil {
+			return nil, err
+		}
+
+		tagValues := make(map[string][]string, len(tagKeys))
+		for _, tagKey := range tagKeys {
+			values, err := c.ListTagValues(bucket, measurement, tagKey, start, stop)
+			if err != nil {
+				return nil, err
+			}
+			tagValues[tagKey] = values
+		}
+
+		schemas[i] = &MeasurementSchema{
+			Name:      measurement,
+			Fields:    fields,
+			TagKeys:   tagKeys,
+			TagValues: tagValues,
+		}
+	}
+
+	return schemas, nil
+}
+
+// Checks the configured measurements and fields exist between start and stop
+func (c *InfluxDbConnector) validateSchema(start time.Time, stop time.Time) error {
+	measurements, err := c.ListMeasurements("", start, stop)
+	if err != nil {
+		return fmt.Errorf("failed to validate schema: %w", err)
+	}
+
+	hasWildcard := false
+	for _, measurement := range c.measurements {
+		if strings.Contains(measurement, "*") {
+			hasWildcard = true
+			continue
+		}
+		if !containsString(measurements, measurement) {
+			return fmt.Errorf("invalid measurement '%s': not found in bucket '%s', available measurements are %s", measurement, c.bucket, strings.Join(measurements, ", "))
+		}
+	}
+
+	if hasWildcard {
+		// Fields can't be checked against a wildcard measurement
+		return nil
+	}
+
+	var fieldNames []string
+	for _, measurement := range c.measurements {
+		fields, err := c.ListFields("", measurement, start, stop)
+		if err != nil {
+			return fmt.Errorf("failed to validate schema: %w", err)
+		}
+		for _, field := range fields {
+			fieldNames = append(fieldNames, field.Name)
+		}
+	}
+
+	for _, field := range c.fields {
+		if strings.Contains(field, "*") {
+			continue
+		}
+		if !containsString(fieldNames, field) {
+			return fmt.Errorf("invalid field '%s': not found in measurement '%s', available fields are %s", field, strings.Join(c.measurements, ","), strings.Join(fieldNames, ", "))
+		}
+	}
+
+	return nil
+}
+
+func (c *InfluxDbConnector) schemaBucket(bucket string) string {
+	if bucket == "" {
+		return c.bucket
+	}
+	return bucket
+}
+
+// Returns the distinct values of column across all tables, sorted
+func (c *InfluxDbConnector) querySchemaValues(query string, column string) ([]string, error) {
+	tables, err := c.querySchemaTables(query)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	values := []string{}
+	for _, table := range tables {
+		for _, row := range table.rows {
+			value, ok := row[column]
+			if !ok || seen[value] {
+				continue
+			}
+			seen[value] = true
+			values = append(values, value)
+		}
+	}
+	sort.Strings(values)
+
+	return values, nil
+}
+
+func (c *InfluxDbConnector) querySchemaTables(query string) ([]*annotatedTable, error) {
+	if c.influxQL != nil {
+		return nil, errors.New("influxdb schema discovery requires InfluxDB 2.x")
+	}
+	if c.currentClient() == nil {
+		return nil, errors.New("influxdb connector is not initialized")
+	}
+
+	data, err := c.withCredentials(func() ([]byte, error) {
+		return c.queryRaw(query)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return readAnnotatedTables(data)
+}
+
+// Parses Flux annotated CSV into tables, starting a new table at each annotation block
+func readAnnotatedTables(data []byte) ([]*annotatedTable, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+
+	var tables []*annotatedTable
+	var table *annotatedTable
+	var datatypes []string
+	var header []string
+	expectHeader := true
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse query result: %w", err)
+		}
+
+		if len(record) > 0 && strings.HasPrefix(record[0], "#") {
+			if record[0] == "#datatype" {
+				datatypes = record
+			}
+			expectHeader = true
+			continue
+		}
+
+		if expectHeader {
+			expectHeader = false
+			header = record
+			table = &annotatedTable{datatypes: make(map[string]string)}
+			for i, column := range header {
+				if i < len(datatypes) {
+					table.datatypes[column] = datatypes[i]
+				}
+			}
+			tables = append(tables, table)
+			datatypes = nil
+			continue
+		}
+
+		row := make(map[string]string, len(header))
+		for i, column := range header {
+			if i < len(record) && column != "" {
+				row[column] = record[i]
+			}
+		}
+		table.rows = append(table.rows, row)
+	}
+
+	return tables, nil
+}
+
+func fluxTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
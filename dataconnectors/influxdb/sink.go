@@ -0,0 +1,284 @@
+package influxdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/spiceai/spiceai/pkg/observations"
+)
+
+var (
+	precisions = map[string]time.Duration{
+		"ns": time.Nanosecond,
+		"us": time.Microsecond,
+		"ms": time.Millisecond,
+		"s":  time.Second,
+	}
+)
+
+// InfluxDbSink writes observations to an InfluxDB 2.x bucket in batches.
+// Observation data keys are written as fields. Tags of the form key=value are written as tags,
+// other tags are joined into the tag named by tags_key.
+type InfluxDbSink struct {
+	client influxdb2.Client
+
+	org         string
+	bucket      string
+	measurement string
+	tagsKey     string
+	precision   time.Duration
+	batchSize   int
+	retryPolicy *retryPolicy
+
+	mutex  sync.Mutex
+	points []*write.Point
+
+	flushInterval time.Duration
+	done          chan bool
+	flushDone     sync.WaitGroup
+}
+
+func NewInfluxDbSink() *InfluxDbSink {
+	return &InfluxDbSink{
+		measurement:   "observations",
+		tagsKey:       "tags",
+		precision:     time.Second,
+		batchSize:     1000,
+		flushInterval: time.Second,
+	}
+}
+
+func (s *InfluxDbSink) Init(params map[string]string) error {
+	if _, ok := params["url"]; !ok {
+		return errors.New("influxdb sink requires the 'url' parameter to be set")
+	}
+
+	if _, ok := params["token"]; !ok {
+		return errors.New("influxdb sink requires the 'token' parameter to be set")
+	}
+
+	s.bucket = params["bucket"]
+	if s.bucket == "" {
+		return errors.New("influxdb sink requires the 'bucket' parameter to be set")
+	}
+
+	s.org = params["org"]
+
+	if measurement, ok := params["measurement"]; ok {
+		if measurement == "" {
+			return errors.New("invalid measurement '': measurement must not be empty")
+		}
+		s.measurement = measurement
+	}
+
+	if tagsKey, ok := params["tags_key"]; ok {
+		s.tagsKey = tagsKey
+	}
+
+	if precision, ok := params["precision"]; ok {
+		p, ok := precisions[precision]
+		if !ok {
+			return fmt.Errorf("invalid precision '%s': must be one of ns, us, ms or s", precision)
+		}
+		s.precision = p
+	}
+
+	if batchSize, ok := params["batch_size"]; ok {
+		bs, err := strconv.Atoi(batchSize)
+		if err != nil {
+			return fmt.Errorf("invalid batch_size '%s': %s", batchSize, err)
+		}
+		if bs <= 0 {
+			return fmt.Errorf("invalid batch_size '%s': batch size must be > 0", batchSize)
+		}
+		s.batchSize = bs
+	}
+
+	if flushInterval, ok := params["flush_interval"]; ok {
+		fi, err := time.ParseDuration(flushInterval)
+		if err != nil {
+			return fmt.Errorf("invalid flush_interval '%s': %s", flushInterval, err)
+		}
+		if fi < 0 {
+			return fmt.Errorf("invalid flush_interval '%s': interval must be >= 0", flushInterval)
+		}
+		s.flushInterval = fi
+	}
+
+	retryPolicy, err := parseRetryPolicy(params)
+	if err != nil {
+		return err
+	}
+	if maxAttempts, ok := params["retry_max_attempts"]; !ok {
+		// Writes block the caller, so don't retry forever by default
+		retryPolicy.maxAttempts = 3
+	} else if retryPolicy.maxAttempts == 0 {
+		return fmt.Errorf("invalid retry_max_attempts '%s': the sink blocks writers while retrying, attempts must be > 0", maxAttempts)
+	}
+	s.retryPolicy = retryPolicy
+
+	transportOptions, err := parseTransportOptions(params)
+	if err != nil {
+		return err
+	}
+
+	clientOptions := transportOptions.clientOptions()
+	clientOptions.SetPrecision(s.precision)
+
+	s.SetInfluxdbClient(influxdb2.NewClientWithOptions(params["url"], params["token"], clientOptions))
+
+	if s.flushInterval > 0 {
+		s.done = make(chan bool)
+		s.flushDone.Add(1)
+		go s.flushPeriodically()
+	}
+
+	return nil
+}
+
+// Write buffers observations, writing a batch once batch_size points are buffered
+func (s *InfluxDbSink) Write(observations []observations.Observation) error {
+	var batches [][]*write.Point
+
+	s.mutex.Lock()
+	for _, observation := range observations {
+		point := s.newPoint(&observation)
+		if point == nil {
+			continue
+		}
+
+		s.points = append(s.points, point)
+		if len(s.points) >= s.batchSize {
+			batches = append(batches, s.points)
+			s.points = nil
+		}
+	}
+	s.mutex.Unlock()
+
+	// Batches are written without holding the mutex so other writers aren't blocked while retrying
+	var writeErr error
+	for _, batch := range batches {
+		err := s.writeBatch(batch)
+		if err != nil && writeErr == nil {
+			writeErr = err
+		}
+	}
+
+	return writeErr
+}
+
+// Flush writes all buffered observations
+func (s *InfluxDbSink) Flush() error {
+	s.mutex.Lock()
+	points := s.points
+	s.points = nil
+	s.mutex.Unlock()
+
+	return s.writeBatch(points)
+}
+
+// Close writes any buffered observations and stops the periodic flush
+func (s *InfluxDbSink) Close() error {
+	if s.done != nil {
+		close(s.done)
+		s.flushDone.Wait()
+		s.done = nil
+	}
+
+	err := s.Flush()
+
+	if s.client != nil {
+		s.client.Close()
+	}
+
+	return err
+}
+
+func (s *InfluxDbSink) SetInfluxdbClient(client influxdb2.Client) {
+	if s.client == nil {
+		s.client = client
+	}
+}
+
+func (s *InfluxDbSink) flushPeriodically() {
+	defer s.flushDone.Done()
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			err := s.Flush()
+			if err != nil {
+				log.Printf("InfluxDb sink flush failed: %s\n", err.Error())
+			}
+		}
+	}
+}
+
+// Writes a batch of points, retrying according to the retry policy.
+// The batch is dropped once retries are exhausted.
+func (s *InfluxDbSink) writeBatch(points []*write.Point) error {
+	if len(points) == 0 {
+		return nil
+	}
+
+	writeAPI := s.client.WriteAPIBlocking(s.org, s.bucket)
+
+	for failures := 0; ; {
+		err := writeAPI.WritePoint(context.Background(), points...)
+		if err == nil {
+			return nil
+		}
+
+		failures++
+		if !s.retryPolicy.shouldRetry(failures) {
+			return fmt.Errorf("failed to write %d points to bucket '%s' after %d attempts: %w", len(points), s.bucket, failures, err)
+		}
+
+		delay := s.retryPolicy.delay(failures)
+		log.Printf("InfluxDb sink write error (attempt %d), retrying in %s: %s\n", failures, delay, err.Error())
+		time.Sleep(delay)
+	}
+}
+
+func (s *InfluxDbSink) newPoint(observation *observations.Observation) *write.Point {
+	if len(observation.Data) == 0 {
+		// InfluxDB requires at least one field
+		return nil
+	}
+
+	fields := make(map[string]interface{}, len(observation.Data))
+	for key, value := range observation.Data {
+		fields[key] = value
+	}
+
+	tags := make(map[string]string)
+	var bareTags []string
+	for _, tag := range observation.Tags {
+		if eqIndex := strings.Index(tag, "="); eqIndex > 0 {
+			tags[tag[:eqIndex]] = tag[eqIndex+1:]
+			continue
+		}
+		bareTags = append(bareTags, tag)
+	}
+
+	if len(bareTags) > 0 && s.tagsKey != "" {
+		sort.Strings(bareTags)
+		tags[s.tagsKey] = strings.Join(bareTags, " ")
+	}
+
+	return influxdb2.NewPoint(s.measurement, tags, fields, time.Unix(observation.Time, 0))
+}
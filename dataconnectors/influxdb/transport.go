@@ -0,0 +1,169 @@
+package influxdb
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+)
+
+// Connection settings shared by the Flux and InfluxQL clients
+type transportOptions struct {
+	tlsConfig *tls.Config
+	timeout   time.Duration
+	proxy     *url.URL
+}
+
+func parseTransportOptions(params map[string]string) (*transportOptions, error) {
+	options := &transportOptions{}
+
+	tlsConfig, err := parseTLSConfig(params)
+	if err != nil {
+		return nil, err
+	}
+	options.tlsConfig = tlsConfig
+
+	if timeout, ok := params["timeout"]; ok {
+		t, err := time.ParseDuration(timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout '%s': %s", timeout, err)
+		}
+		if t <= 0 {
+			return nil, fmt.Errorf("invalid timeout '%s': timeout must be > 0", timeout)
+		}
+		options.timeout = t
+	}
+
+	if proxy, ok := params["proxy"]; ok {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy '%s': %s", proxy, err)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("invalid proxy '%s': scheme must be http, https or socks5", proxy)
+		}
+		if proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy '%s': missing host", proxy)
+		}
+		options.proxy = proxyURL
+	}
+
+	return options, nil
+}
+
+// Builds the TLS config from the tls_* params, or returns nil if none are set
+func parseTLSConfig(params map[string]string) (*tls.Config, error) {
+	caPath, hasCA := params["tls_ca"]
+	certPath, hasCert := params["tls_cert"]
+	keyPath, hasKey := params["tls_key"]
+	insecureSkipVerify, hasInsecureSkipVerify := params["tls_insecure_skip_verify"]
+
+	if !hasCA && !hasCert && !hasKey && !hasInsecureSkipVerify {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if hasCA {
+		caData, err := os.ReadFile(appPath(params, caPath))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tls_ca '%s': %w", caPath, err)
+		}
+
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("invalid tls_ca '%s': no PEM certificates found", caPath)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	if hasCert != hasKey {
+		return nil, errors.New("influxdb connector requires both 'tls_cert' and 'tls_key' to be set for client certificates")
+	}
+
+	if hasCert {
+		certificate, err := tls.LoadX509KeyPair(appPath(params, certPath), appPath(params, keyPath))
+		if err != nil {
+			return nil, fmt.Errorf("invalid tls_cert '%s' or tls_key '%s': %w", certPath, keyPath, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	if hasInsecureSkipVerify {
+		skip, err := strconv.ParseBool(insecureSkipVerify)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tls_insecure_skip_verify '%s': %s", insecureSkipVerify, err)
+		}
+		if skip {
+			log.Printf("InfluxDb connector TLS certificate verification is disabled")
+		}
+		tlsConfig.InsecureSkipVerify = skip
+	}
+
+	return tlsConfig, nil
+}
+
+// Resolves paths relative to the app directory
+func appPath(params map[string]string, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(params["appDirectory"], path)
+}
+
+// Options for the InfluxDB 2.x client
+func (o *transportOptions) clientOptions() *influxdb2.Options {
+	options := influxdb2.DefaultOptions()
+
+	if o.tlsConfig != nil {
+		options.SetTLSConfig(o.tlsConfig)
+	}
+
+	if o.timeout > 0 {
+		// The client only supports whole seconds, so round up
+		seconds := (o.timeout + time.Second - 1) / time.Second
+		options.SetHTTPRequestTimeout(uint(seconds))
+	}
+
+	if o.proxy != nil {
+		// Adds the proxy to the transport the client builds from the options above
+		transport := options.HTTPClient().Transport.(*http.Transport)
+		transport.Proxy = http.ProxyURL(o.proxy)
+	}
+
+	return options
+}
+
+// HTTP client for the InfluxDB 1.x API
+func (o *transportOptions) httpClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if o.tlsConfig != nil {
+		transport.TLSClientConfig = o.tlsConfig
+	}
+	if o.proxy != nil {
+		transport.Proxy = http.ProxyURL(o.proxy)
+	}
+
+	timeout := defaultInfluxQLTimeout
+	if o.timeout > 0 {
+		timeout = o.timeout
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}
+}
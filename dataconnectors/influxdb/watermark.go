@@ -0,0 +1,94 @@
+package influxdb
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strings"
+	"time"
+)
+
+func parseLateness(params map[string]string, interval time.Duration) (time.Duration, error) {
+	lateness, ok := params["lateness"]
+	if !ok {
+		// Default to re-reading one interval, which also refreshes the last partial aggregate window
+		return interval, nil
+	}
+
+	l, err := time.ParseDuration(lateness)
+	if err != nil {
+		return 0, fmt.Errorf("invalid lateness '%s': %s", lateness, err)
+	}
+	if l < 0 {
+		return 0, fmt.Errorf("invalid lateness '%s': lateness must be >= 0", lateness)
+	}
+
+	return l, nil
+}
+
+// Returns the sliding window to fetch: everything from the watermark less the allowed lateness up to now,
+// but never further back than period
+func (c *InfluxDbConnector) slidingWindow(period time.Duration) (time.Time, time.Time) {
+	periodEnd := now().UTC()
+	periodStart := periodEnd.Add(-period)
+
+	if !c.watermark.IsZero() {
+		watermarkStart := c.watermark.Add(-c.lateness)
+		if watermarkStart.After(periodStart) {
+			periodStart = watermarkStart
+		}
+	}
+
+	return periodStart, periodEnd
+}
+
+// Returns the latest _time in Flux annotated CSV, or false if there are no records with a _time column
+func maxRecordTime(data []byte) (time.Time, bool) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	var maxTime time.Time
+	timeCol := -1
+	expectHeader := true
+
+	for {
+		record, err := reader.Read()
+		if err != nil {
+			// io.EOF or data that isn't CSV
+			break
+		}
+
+		if len(record) > 0 && strings.HasPrefix(record[0], "#") {
+			// Annotations precede the header of each table
+			expectHeader = true
+			continue
+		}
+
+		if expectHeader {
+			expectHeader = false
+			timeCol = -1
+			for i, column := range record {
+				if column == "_time" {
+					timeCol = i
+					break
+				}
+			}
+			continue
+		}
+
+		if timeCol < 0 || timeCol >= len(record) {
+			continue
+		}
+
+		recordTime, err := time.Parse(time.RFC3339Nano, record[timeCol])
+		if err != nil {
+			continue
+		}
+		if recordTime.After(maxTime) {
+			maxTime = recordTime
+		}
+	}
+
+	return maxTime, !maxTime.IsZero()
+}
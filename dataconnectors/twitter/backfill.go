@@ -0,0 +1,433 @@
+package twitter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+const (
+	BackfillRunning  string = "running"
+	BackfillComplete string = "complete"
+	BackfillFailed   string = "failed"
+
+	searchTweetsPath string = "/1.1/search/tweets.json"
+
+	// The standard search APIs only return tweets from the last 7 days
+	searchHistory = 7 * 24 * time.Hour
+
+	maxSearchAttempts = 3
+	searchPageSize    = "100"
+
+	// Live tweets held back while the backfill runs, which may wait out rate limits for minutes
+	defaultBackfillBuffer = 10000
+)
+
+// Fetches tweets for the dataspace window from the search API before handing over to the live stream.
+// Live tweets are held back until the backfill completes, so they are delivered after the history.
+type tweetBackfill struct {
+	epoch  time.Time
+	period time.Duration
+
+	mutex      sync.Mutex
+	running    bool
+	pending    []*pendingTweet
+	maxPending int
+	dropped    int
+	delivered  map[string]bool
+}
+
+type pendingTweet struct {
+	dataType     string
+	id           string
+	tweet        []byte
+	matchedRules []string
+}
+
+// Returns the backfill for the dataspace window, or nil if there is no window or 'backfill' isn't true.
+// Backfill is opt-in since it spends search API rate limits on startup.
+// 'backfill_buffer' limits the live tweets held back meanwhile, later ones are dropped.
+func parseTweetBackfill(params map[string]string, epoch time.Time, period time.Duration) (*tweetBackfill, error) {
+	enabled := false
+	if backfill, ok := params["backfill"]; ok {
+		b, err := strconv.ParseBool(backfill)
+		if err != nil {
+			return nil, fmt.Errorf("invalid backfill '%s': %s", backfill, err)
+		}
+		enabled = b
+	}
+
+	maxPending := defaultBackfillBuffer
+	if backfillBuffer, ok := params["backfill_buffer"]; ok {
+		bb, err := strconv.Atoi(backfillBuffer)
+		if err != nil {
+			return nil, fmt.Errorf("invalid backfill_buffer '%s': %s", backfillBuffer, err)
+		}
+		if bb <= 0 {
+			return nil, fmt.Errorf("invalid backfill_buffer '%s': buffer size must be > 0", backfillBuffer)
+		}
+		maxPending = bb
+	}
+
+	if !enabled || period <= 0 {
+		return nil, nil
+	}
+
+	return &tweetBackfill{epoch: epoch, period: period, maxPending: maxPending}, nil
+}
+
+// Returns the part of the dataspace window the search API can still return
+func (b *tweetBackfill) window(now time.Time) (time.Time, time.Time) {
+	var start, end time.Time
+	if b.epoch.IsZero() {
+		// Epoch not set - the period up to now
+		end = now
+		start = end.Add(-b.period)
+	} else {
+		start = b.epoch
+		end = start.Add(b.period)
+		if end.After(now) {
+			end = now
+		}
+	}
+
+	earliest := now.Add(-searchHistory)
+	if start.Before(earliest) {
+		log.Printf("twitter backfill limited to the last %s, tweets before %s are not available from search", searchHistory, earliest.Format(time.RFC3339))
+		start = earliest
+	}
+
+	return start.UTC(), end.UTC()
+}
+
+// Starts holding back live tweets
+func (b *tweetBackfill) begin() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.running = true
+	b.delivered = make(map[string]bool)
+}
+
+// Holds back a live tweet while the backfill runs, returning whether it was held.
+// Once the buffer is full, live tweets are dropped until the backfill completes.
+func (b *tweetBackfill) hold(tweet *pendingTweet) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if !b.running {
+		return false
+	}
+	if len(b.pending) >= b.maxPending {
+		if b.dropped == 0 {
+			log.Printf("twitter backfill buffer of %d live tweets is full, dropping live tweets until the backfill completes", b.maxPending)
+		}
+		b.dropped++
+		return true
+	}
+	b.pending = append(b.pending, tweet)
+	return true
+}
+
+// Runs search over the backfill window, then delivers the live tweets held back meanwhile
+func (c *TwitterConnector) runBackfill(search func(start time.Time, end time.Time) error) {
+	start, end := c.backfill.window(time.Now())
+
+	c.statusMutex.Lock()
+	c.status.BackfillState = BackfillRunning
+	c.statusMutex.Unlock()
+
+	var err error
+	if start.Before(end) {
+		log.Printf("twitter backfill from %s to %s", start.Format(time.RFC3339), end.Format(time.RFC3339))
+		err = search(start, end)
+	}
+
+	dropped := c.finishBackfill()
+
+	c.statusMutex.Lock()
+	defer c.statusMutex.Unlock()
+
+	c.status.BackfillDropped = dropped
+	if dropped > 0 {
+		log.Printf("twitter backfill dropped %d live tweets", dropped)
+	}
+
+	if err != nil {
+		log.Printf("twitter backfill failed: %s", err.Error())
+		c.status.BackfillState = BackfillFailed
+		c.status.BackfillError = err.Error()
+		return
+	}
+
+	log.Printf("twitter backfill complete, %d tweets", c.status.BackfillTweets)
+	c.status.BackfillState = BackfillComplete
+}
+
+// Delivers the held back live tweets, skipping any the backfill already delivered.
+// Returns the number of live tweets dropped because the buffer was full.
+func (c *TwitterConnector) finishBackfill() int {
+	b := c.backfill
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for _, tweet := range b.pending {
+		if !b.delivered[tweet.id] {
+			c.deliverTweet(tweet.dataType, tweet.tweet, tweet.matchedRules)
+		}
+	}
+
+	dropped := b.dropped
+
+	b.running = false
+	b.pending = nil
+	b.dropped = 0
+	b.delivered = nil
+
+	return dropped
+}
+
+// Delivers a tweet from search once, as several searches may return it
+func (c *TwitterConnector) sendBackfillTweet(dataType string, id string, tweet []byte, matchedRules []string) {
+	b := c.backfill
+	b.mutex.Lock()
+	if b.delivered[id] {
+		b.mutex.Unlock()
+		return
+	}
+	b.delivered[id] = true
+	b.mutex.Unlock()
+
+	c.statusMutex.Lock()
+	c.status.BackfillTweets++
+	c.statusMutex.Unlock()
+
+	c.deliverTweet(dataType, tweet, matchedRules)
+}
+
+// Fetches a page of search results, waiting out rate limits and retrying transient errors
+func (c *TwitterConnector) search(newRequest func() (*http.Request, error), out interface{}) error {
+	backoff := &reconnectBackoff{}
+
+	for failures := 0; ; {
+		req, err := newRequest()
+		if err != nil {
+			return err
+		}
+
+		kind := networkBackoff
+		resp, err := c.httpClient.Do(req)
+		if err == nil {
+			var respData []byte
+			respData, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			switch {
+			case err != nil:
+			case resp.StatusCode == http.StatusOK:
+				if resp.Header.Get("x-rate-limit-remaining") == "0" {
+					waitForRateLimit(resp.Header)
+				}
+				return json.Unmarshal(respData, out)
+			case resp.StatusCode == 420 || resp.StatusCode == http.StatusTooManyRequests:
+				// Rate limits aren't failures, wait for the window to reset
+				waitForRateLimit(resp.Header)
+				continue
+			case resp.StatusCode >= 500:
+				err = responseError(req.Method, req.URL.Path, resp, respData)
+				kind = httpBackoff
+			default:
+				return responseError(req.Method, req.URL.Path, resp, respData)
+			}
+		}
+
+		failures++
+		if failures >= maxSearchAttempts {
+			return fmt.Errorf("twitter search failed after %d attempts: %w", failures, err)
+		}
+
+		delay := backoff.next(kind)
+		log.Printf("twitter search error (attempt %d), retrying in %s: %s", failures, delay, err.Error())
+		time.Sleep(delay)
+	}
+}
+
+// Sleeps until the rate limit window in the response headers resets, or for a full 15 minute window if it isn't given
+func waitForRateLimit(header http.Header) {
+	delay := 15 * time.Minute
+	if reset, err := strconv.ParseInt(header.Get("x-rate-limit-reset"), 10, 64); err == nil {
+		delay = time.Until(time.Unix(reset, 0))
+	}
+	if delay <= 0 {
+		return
+	}
+
+	log.Printf("twitter search rate limited, waiting %s", delay.Round(time.Second))
+	time.Sleep(delay)
+}
+
+// Searches the v1.1 standard search API for the track terms, newest first, paging back with max_id
+func (c *TwitterConnector) searchV1(start time.Time, end time.Time) error {
+	if len(c.filter.track) == 0 {
+		log.Println("twitter backfill skipped, only track terms can be searched")
+		return nil
+	}
+	if len(c.filter.follow) > 0 || len(c.filter.locations) > 0 {
+		log.Println("twitter backfill only covers track terms, follow and locations are not searchable")
+	}
+
+	query := url.Values{}
+	query.Set("q", c.filter.searchQuery())
+	query.Set("count", searchPageSize)
+	query.Set("result_type", "recent")
+	query.Set("tweet_mode", "extended")
+	query.Set("include_entities", "true")
+	if len(c.filter.language) == 1 {
+		query.Set("lang", c.filter.language[0])
+	}
+
+	for {
+		reqURL := strings.TrimSuffix(c.apiURL, "/") + searchTweetsPath + "?" + query.Encode()
+
+		var page struct {
+			Statuses []*twitter.Tweet `json:"statuses"`
+		}
+		err := c.search(func() (*http.Request, error) {
+			return http.NewRequest(http.MethodGet, reqURL, nil)
+		}, &page)
+		if err != nil {
+			return err
+		}
+
+		var oldestID int64
+		reachedStart := false
+		for _, tweet := range page.Statuses {
+			if id, err := strconv.ParseInt(tweet.IDStr, 10, 64); err == nil && (oldestID == 0 || id < oldestID) {
+				oldestID = id
+			}
+
+			createdAt, err := tweet.CreatedAtTime()
+			if err != nil {
+				continue
+			}
+			if createdAt.Before(start) {
+				reachedStart = true
+				continue
+			}
+			if !createdAt.Before(end) || !c.filter.matchesLanguage(tweet) {
+				continue
+			}
+
+			tweetJson, err := json.Marshal(tweet)
+			if err != nil {
+				return err
+			}
+			c.sendBackfillTweet("tweet", tweet.IDStr, tweetJson, c.filter.matchedRules(tweet))
+		}
+
+		if reachedStart || oldestID <= 1 {
+			return nil
+		}
+		query.Set("max_id", strconv.FormatInt(oldestID-1, 10))
+	}
+}
+
+// Searches the v2 recent search API rule by rule, so each tweet is tagged with the rule that found it
+func (c *TwitterConnector) searchV2(rules []*streamRule, params map[string]string) func(start time.Time, end time.Time) error {
+	return func(start time.Time, end time.Time) error {
+		// end_time must be at least 10 seconds before the request
+		if wait := time.Until(end.Add(10 * time.Second)); wait > 0 {
+			time.Sleep(wait)
+		}
+
+		for _, rule := range rules {
+			err := c.searchV2Rule(rule, params, start, end)
+			if err != nil {
+				return fmt.Errorf("rule '%s': %w", rule.Value, err)
+			}
+		}
+
+		return nil
+	}
+}
+
+func (c *TwitterConnector) searchV2Rule(rule *streamRule, params map[string]string, start time.Time, end time.Time) error {
+	query := url.Values{}
+	query.Set("query", rule.Value)
+	query.Set("start_time", start.Format(time.RFC3339))
+	query.Set("end_time", end.Format(time.RFC3339))
+	query.Set("max_results", searchPageSize)
+	query.Set("expansions", paramOrDefault(params, "expansions", defaultExpansions))
+	query.Set("tweet.fields", paramOrDefault(params, "tweet_fields", defaultTweetFields))
+	query.Set("user.fields", paramOrDefault(params, "user_fields", defaultUserFields))
+
+	matchingRules := []*streamRule{{ID: rule.ID, Value: rule.Value, Tag: rule.Tag}}
+
+	for {
+		var page struct {
+			Data     []json.RawMessage `json:"data"`
+			Includes struct {
+				Users []json.RawMessage `json:"users"`
+			} `json:"includes"`
+			Meta struct {
+				NextToken string `json:"next_token"`
+			} `json:"meta"`
+		}
+		err := c.search(func() (*http.Request, error) {
+			return c.v2.newRequest(context.Background(), http.MethodGet, "/2/tweets/search/recent", query, nil)
+		}, &page)
+		if err != nil {
+			return err
+		}
+
+		users := make(map[string]json.RawMessage, len(page.Includes.Users))
+		for _, user := range page.Includes.Users {
+			var userID struct {
+				ID string `json:"id"`
+			}
+			if json.Unmarshal(user, &userID) == nil {
+				users[userID.ID] = user
+			}
+		}
+
+		for _, data := range page.Data {
+			var tweet struct {
+				ID       string `json:"id"`
+				AuthorID string `json:"author_id"`
+			}
+			if json.Unmarshal(data, &tweet) != nil {
+				continue
+			}
+
+			// Shape results like stream payloads so the tweet_v2 format reads both
+			payload := map[string]interface{}{
+				"data":           data,
+				"matching_rules": matchingRules,
+			}
+			if user, ok := users[tweet.AuthorID]; ok {
+				payload["includes"] = map[string]interface{}{"users": []json.RawMessage{user}}
+			}
+
+			payloadJson, err := json.Marshal(payload)
+			if err != nil {
+				return err
+			}
+			c.sendBackfillTweet("tweet_v2", tweet.ID, payloadJson, []string{rule.Tag})
+		}
+
+		if page.Meta.NextToken == "" {
+			return nil
+		}
+		query.Set("next_token", page.Meta.NextToken)
+	}
+}
@@ -0,0 +1,158 @@
+package twitter
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBatchSize int = 1000
+)
+
+// Buffers tweets and delivers them as one array once batch_size tweets are buffered
+// or batch_interval has passed since the first tweet of the batch
+type tweetBatch struct {
+	maxSize  int
+	interval time.Duration
+	send     func(data []byte, metadata map[string]string)
+
+	mutex    sync.Mutex
+	dataType string
+	tweets   [][]byte
+	rules    []string
+	ruleSet  map[string]bool
+	start    time.Time
+	timer    *time.Timer
+	timerSeq int
+}
+
+// Returns the batch configured by batch_size and batch_interval, or nil if neither is set.
+// batch_interval defaults to the dataspace interval.
+func parseTweetBatch(params map[string]string, interval time.Duration) (*tweetBatch, error) {
+	batchSize, hasSize := params["batch_size"]
+	batchInterval, hasInterval := params["batch_interval"]
+	if !hasSize && !hasInterval {
+		return nil, nil
+	}
+
+	b := &tweetBatch{
+		maxSize:  defaultBatchSize,
+		interval: interval,
+	}
+
+	if hasSize {
+		bs, err := strconv.Atoi(batchSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid batch_size '%s': %s", batchSize, err)
+		}
+		if bs <= 0 {
+			return nil, fmt.Errorf("invalid batch_size '%s': batch size must be > 0", batchSize)
+		}
+		b.maxSize = bs
+	}
+
+	if hasInterval {
+		bi, err := time.ParseDuration(batchInterval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid batch_interval '%s': %s", batchInterval, err)
+		}
+		if bi <= 0 {
+			return nil, fmt.Errorf("invalid batch_interval '%s': interval must be > 0", batchInterval)
+		}
+		b.interval = bi
+	}
+
+	if b.interval <= 0 {
+		return nil, errors.New("batch_interval is required when the dataspace has no interval")
+	}
+
+	return b, nil
+}
+
+// Adds a tweet, delivering the batch if it is full
+func (b *tweetBatch) add(dataType string, tweet []byte, matchedRules []string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if len(b.tweets) > 0 && b.dataType != dataType {
+		b.flush()
+	}
+
+	if len(b.tweets) == 0 {
+		b.dataType = dataType
+		b.start = time.Now()
+		b.ruleSet = make(map[string]bool)
+
+		b.timerSeq++
+		seq := b.timerSeq
+		b.timer = time.AfterFunc(b.interval, func() {
+			b.mutex.Lock()
+			defer b.mutex.Unlock()
+			// A batch filled up before the interval passed has already been delivered
+			if seq == b.timerSeq {
+				b.flush()
+			}
+		})
+	}
+
+	b.tweets = append(b.tweets, tweet)
+	for _, rule := range matchedRules {
+		if !b.ruleSet[rule] {
+			b.ruleSet[rule] = true
+			b.rules = append(b.rules, rule)
+		}
+	}
+
+	if len(b.tweets) >= b.maxSize {
+		b.flush()
+	}
+}
+
+// Delivers the buffered tweets. Callers must hold the mutex, which keeps batches in order.
+func (b *tweetBatch) flush() {
+	if len(b.tweets) == 0 {
+		return
+	}
+
+	b.timer.Stop()
+	b.timerSeq++
+
+	metadata := map[string]string{}
+	metadata["type"] = b.dataType
+	metadata["matched_rules"] = strings.Join(b.rules, ",")
+	metadata["batch_start"] = b.start.Format(time.RFC3339Nano)
+	metadata["batch_end"] = time.Now().Format(time.RFC3339Nano)
+	metadata["batch_count"] = strconv.Itoa(len(b.tweets))
+
+	data := tweetArray(b.tweets...)
+
+	b.tweets = nil
+	b.rules = nil
+	b.ruleSet = nil
+
+	b.send(data, metadata)
+}
+
+// Joins JSON encoded tweets into a JSON array
+func tweetArray(tweets ...[]byte) []byte {
+	size := 2
+	for _, tweet := range tweets {
+		size += len(tweet) + 1
+	}
+
+	data := make([]byte, 0, size)
+	data = append(data, '[')
+	for i, tweet := range tweets {
+		if i > 0 {
+			data = append(data, ',')
+		}
+		data = append(data, tweet...)
+	}
+	data = append(data, ']')
+
+	return data
+}
@@ -0,0 +1,308 @@
+package twitter
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+const (
+	trackRule     string = "track"
+	followRule    string = "follow"
+	locationsRule string = "locations"
+)
+
+var (
+	validFilterLevels = map[string]bool{
+		"none":   true,
+		"low":    true,
+		"medium": true,
+	}
+)
+
+type boundingBox struct {
+	swLon float64
+	swLat float64
+	neLon float64
+	neLat float64
+}
+
+// The stream filter from the params, also used to work out which rules each tweet matched
+type streamFilter struct {
+	track       []string
+	follow      []string
+	locations   []*boundingBox
+	language    []string
+	filterLevel string
+}
+
+// Splits a comma-separated list param, ignoring empty entries
+func parseListParam(param string) []string {
+	var items []string
+	for _, item := range strings.Split(param, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func parseStreamFilter(params map[string]string) (*streamFilter, error) {
+	f := &streamFilter{}
+
+	// filter is the original single track term
+	f.track = append(parseListParam(params["filter"]), parseListParam(params["track"])...)
+
+	f.follow = parseListParam(params["follow"])
+	for _, userID := range f.follow {
+		if _, err := strconv.ParseInt(userID, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid follow '%s': user IDs must be numeric", userID)
+		}
+	}
+
+	locations := parseListParam(params["locations"])
+	if len(locations)%4 != 0 {
+		return nil, fmt.Errorf("invalid locations '%s': expected groups of sw_lon,sw_lat,ne_lon,ne_lat", params["locations"])
+	}
+	for i := 0; i < len(locations); i += 4 {
+		var coords [4]float64
+		for j := range coords {
+			value, err := strconv.ParseFloat(locations[i+j], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid locations '%s': %s", params["locations"], err)
+			}
+			coords[j] = value
+		}
+
+		box := &boundingBox{swLon: coords[0], swLat: coords[1], neLon: coords[2], neLat: coords[3]}
+		if box.swLon < -180 || box.neLon > 180 || box.swLat < -90 || box.neLat > 90 || box.swLon > box.neLon || box.swLat > box.neLat {
+			return nil, fmt.Errorf("invalid locations '%s': bounding box %s is out of range", params["locations"], strings.Join(locations[i:i+4], ","))
+		}
+		f.locations = append(f.locations, box)
+	}
+
+	f.language = parseListParam(params["language"])
+
+	if filterLevel, ok := params["filter_level"]; ok {
+		if !validFilterLevels[filterLevel] {
+			return nil, fmt.Errorf("invalid filter_level '%s': must be one of none, low or medium", filterLevel)
+		}
+		f.filterLevel = filterLevel
+	}
+
+	if len(f.track) == 0 && len(f.follow) == 0 && len(f.locations) == 0 {
+		return nil, errors.New("filter is required: at least one of 'track', 'follow' or 'locations' must be set")
+	}
+
+	return f, nil
+}
+
+// The query for the filter endpoint, requesting stall warnings
+func (f *streamFilter) streamQuery() url.Values {
+	query := url.Values{}
+	if len(f.track) > 0 {
+		query.Set("track", strings.Join(f.track, ","))
+	}
+	if len(f.follow) > 0 {
+		query.Set("follow", strings.Join(f.follow, ","))
+	}
+	if len(f.locations) > 0 {
+		var locations []string
+		for _, box := range f.locations {
+			locations = append(locations, box.String())
+		}
+		query.Set("locations", strings.Join(locations, ","))
+	}
+	if len(f.language) > 0 {
+		query.Set("language", strings.Join(f.language, ","))
+	}
+	if f.filterLevel != "" {
+		query.Set("filter_level", f.filterLevel)
+	}
+	query.Set("stall_warnings", "true")
+	return query
+}
+
+func (f *streamFilter) String() string {
+	var rules []string
+	if len(f.track) > 0 {
+		rules = append(rules, fmt.Sprintf("track: %s", strings.Join(f.track, ", ")))
+	}
+	if len(f.follow) > 0 {
+		rules = append(rules, fmt.Sprintf("follow: %s", strings.Join(f.follow, ", ")))
+	}
+	for _, box := range f.locations {
+		rules = append(rules, fmt.Sprintf("locations: %s", box))
+	}
+	return strings.Join(rules, "; ")
+}
+
+// The search API query for the track terms. A term of several words matches all of them, as in the stream.
+func (f *streamFilter) searchQuery() string {
+	var terms []string
+	for _, term := range f.track {
+		if strings.Contains(term, " ") {
+			term = "(" + term + ")"
+		}
+		terms = append(terms, term)
+	}
+	return strings.Join(terms, " OR ")
+}
+
+// Whether tweet is in one of the filter languages, as the stream would only deliver those
+func (f *streamFilter) matchesLanguage(tweet *twitter.Tweet) bool {
+	if len(f.language) == 0 {
+		return true
+	}
+	for _, language := range f.language {
+		if tweet.Lang == language {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the rules that selected tweet, e.g. "track:bitcoin" or "follow:783214".
+// Twitter ORs the rules together and doesn't say which matched, so they are matched again here.
+func (f *streamFilter) matchedRules(tweet *twitter.Tweet) []string {
+	var rules []string
+
+	if len(f.track) > 0 {
+		text := strings.ToLower(strings.Join(tweetTrackText(tweet), " "))
+		for _, term := range f.track {
+			if trackTermMatches(text, term) {
+				rules = append(rules, trackRule+":"+term)
+			}
+		}
+	}
+
+	for _, userID := range f.follow {
+		if followMatches(tweet, userID) {
+			rules = append(rules, followRule+":"+userID)
+		}
+	}
+
+	for _, box := range f.locations {
+		if box.matches(tweet) {
+			rules = append(rules, locationsRule+":"+box.String())
+		}
+	}
+
+	return rules
+}
+
+// Text Twitter matches track terms against: the text, hashtags, URLs and screen names
+func tweetTrackText(tweet *twitter.Tweet) []string {
+	var text []string
+	if tweet.ExtendedTweet != nil && tweet.ExtendedTweet.FullText != "" {
+		text = append(text, tweet.ExtendedTweet.FullText)
+	} else if tweet.FullText != "" {
+		text = append(text, tweet.FullText)
+	} else {
+		text = append(text, tweet.Text)
+	}
+
+	if tweet.User != nil {
+		text = append(text, "@"+tweet.User.ScreenName)
+	}
+
+	if tweet.Entities != nil {
+		for _, hashtag := range tweet.Entities.Hashtags {
+			text = append(text, "#"+hashtag.Text)
+		}
+		for _, urlEntity := range tweet.Entities.Urls {
+			text = append(text, urlEntity.ExpandedURL, urlEntity.DisplayURL)
+		}
+		for _, mention := range tweet.Entities.UserMentions {
+			text = append(text, "@"+mention.ScreenName)
+		}
+	}
+
+	if tweet.RetweetedStatus != nil {
+		text = append(text, tweetTrackText(tweet.RetweetedStatus)...)
+	}
+	if tweet.QuotedStatus != nil {
+		text = append(text, tweetTrackText(tweet.QuotedStatus)...)
+	}
+
+	return text
+}
+
+// A term of several words matches if every word appears, in any order
+func trackTermMatches(text string, term string) bool {
+	for _, word := range strings.Fields(strings.ToLower(term)) {
+		if !strings.Contains(text, word) {
+			return false
+		}
+	}
+	return true
+}
+
+// Twitter delivers tweets by, retweets of and replies to followed users
+func followMatches(tweet *twitter.Tweet, userID string) bool {
+	if tweet.User != nil && tweet.User.IDStr == userID {
+		return true
+	}
+	if tweet.InReplyToUserIDStr == userID {
+		return true
+	}
+	if tweet.RetweetedStatus != nil && tweet.RetweetedStatus.User != nil && tweet.RetweetedStatus.User.IDStr == userID {
+		return true
+	}
+	return false
+}
+
+// Matches exact coordinates within the box, or a place whose bounding box intersects it
+func (b *boundingBox) matches(tweet *twitter.Tweet) bool {
+	if tweet.Coordinates != nil {
+		lon, lat := tweet.Coordinates.Coordinates[0], tweet.Coordinates.Coordinates[1]
+		return lon >= b.swLon && lon <= b.neLon && lat >= b.swLat && lat <= b.neLat
+	}
+
+	if tweet.Place == nil || tweet.Place.BoundingBox == nil {
+		return false
+	}
+
+	for _, polygon := range tweet.Place.BoundingBox.Coordinates {
+		if len(polygon) == 0 {
+			continue
+		}
+
+		minLon, minLat := polygon[0][0], polygon[0][1]
+		maxLon, maxLat := minLon, minLat
+		for _, point := range polygon[1:] {
+			if point[0] < minLon {
+				minLon = point[0]
+			}
+			if point[0] > maxLon {
+				maxLon = point[0]
+			}
+			if point[1] < minLat {
+				minLat = point[1]
+			}
+			if point[1] > maxLat {
+				maxLat = point[1]
+			}
+		}
+
+		if minLon <= b.neLon && maxLon >= b.swLon && minLat <= b.neLat && maxLat >= b.swLat {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (b *boundingBox) String() string {
+	return strings.Join([]string{
+		strconv.FormatFloat(b.swLon, 'f', -1, 64),
+		strconv.FormatFloat(b.swLat, 'f', -1, 64),
+		strconv.FormatFloat(b.neLon, 'f', -1, 64),
+		strconv.FormatFloat(b.neLat, 'f', -1, 64),
+	}, ",")
+}
@@ -0,0 +1,364 @@
+package twitter
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	redactTextStrip    string = "strip"
+	redactTextTruncate string = "truncate"
+
+	defaultRedactTextLength int = 100
+	minRedactSaltLength     int = 16
+
+	// Bytes of the salted hash kept for a pseudonym
+	pseudonymLength int = 16
+)
+
+var (
+	// Mentions start the text or follow a non-word character, so email addresses aren't mentions
+	mentionPattern = regexp.MustCompile(`(^|[^A-Za-z0-9_])@[A-Za-z0-9_]{1,15}\b`)
+	// Links to tweets name their author, e.g. https://twitter.com/<screen_name>/status/<id>
+	statusURLPattern = regexp.MustCompile(`(?i)((?:^|/|\.)twitter\.com/)([A-Za-z0-9_]{1,15})(/status(?:es)?/)`)
+
+	// User fields kept when pseudonymizing, everything else about the author is dropped
+	v1UserFields = map[string]bool{
+		"id_str":           true,
+		"screen_name":      true,
+		"followers_count":  true,
+		"friends_count":    true,
+		"statuses_count":   true,
+		"favourites_count": true,
+		"listed_count":     true,
+		"verified":         true,
+		"protected":        true,
+		"created_at":       true,
+		"lang":             true,
+		"location":         true,
+	}
+	v2UserFields = map[string]bool{
+		"id":             true,
+		"username":       true,
+		"public_metrics": true,
+		"verified":       true,
+		"protected":      true,
+		"created_at":     true,
+		"location":       true,
+	}
+)
+
+// Removes personal data from tweets before they leave the connector
+type tweetRedaction struct {
+	// Replaces user IDs and screen names with salted hashes when set
+	salt []byte
+	// Strips or truncates the tweet text when set
+	text       string
+	textLength int
+	// Drops coordinates, places and user locations
+	dropGeo bool
+}
+
+// Returns the redaction configured by the redact_* params, or nil if there is none
+func parseTweetRedaction(params map[string]string) (*tweetRedaction, error) {
+	r := &tweetRedaction{}
+
+	redactUsers, err := parseBoolParam(params, "redact_users")
+	if err != nil {
+		return nil, err
+	}
+	if redactUsers {
+		salt := params["redact_salt"]
+		if salt == "" {
+			return nil, errors.New("redact_salt is required when redact_users is true")
+		}
+		if len(salt) < minRedactSaltLength {
+			return nil, fmt.Errorf("invalid redact_salt: salt must be at least %d characters", minRedactSaltLength)
+		}
+		r.salt = []byte(salt)
+	}
+
+	switch text := params["redact_text"]; text {
+	case "":
+	case redactTextStrip:
+		r.text = text
+	case redactTextTruncate:
+		r.text = text
+		r.textLength = defaultRedactTextLength
+		if textLength, ok := params["redact_text_length"]; ok {
+			tl, err := strconv.Atoi(textLength)
+			if err != nil {
+				return nil, fmt.Errorf("invalid redact_text_length '%s': %s", textLength, err)
+			}
+			if tl <= 0 {
+				return nil, fmt.Errorf("invalid redact_text_length '%s': length must be > 0", textLength)
+			}
+			r.textLength = tl
+		}
+	default:
+		return nil, fmt.Errorf("invalid redact_text '%s': must be %s or %s", text, redactTextStrip, redactTextTruncate)
+	}
+
+	r.dropGeo, err = parseBoolParam(params, "redact_geo")
+	if err != nil {
+		return nil, err
+	}
+
+	if r.salt == nil && r.text == "" && !r.dropGeo {
+		return nil, nil
+	}
+
+	return r, nil
+}
+
+func parseBoolParam(params map[string]string, param string) (bool, error) {
+	value, ok := params[param]
+	if !ok {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s '%s': %s", param, value, err)
+	}
+	return b, nil
+}
+
+// Redacts a JSON encoded tweet of dataType, a v1.1 tweet or a v2 stream payload
+func (r *tweetRedaction) redact(dataType string, tweet []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(tweet))
+	// Keep IDs exact, they don't fit in a float64
+	decoder.UseNumber()
+
+	var message map[string]interface{}
+	err := decoder.Decode(&message)
+	if err != nil {
+		return nil, err
+	}
+
+	if dataType == "tweet_v2" {
+		r.redactV2Payload(message)
+	} else {
+		r.redactV1Tweet(message)
+	}
+
+	return json.Marshal(message)
+}
+
+func (r *tweetRedaction) redactV1Tweet(tweet map[string]interface{}) {
+	if r.salt != nil {
+		if user, ok := tweet["user"].(map[string]interface{}); ok {
+			r.redactUser(user, v1UserFields, "id_str", "screen_name")
+		}
+
+		delete(tweet, "in_reply_to_user_id")
+		r.pseudonymizeField(tweet, "in_reply_to_user_id_str", "id")
+		r.pseudonymizeField(tweet, "in_reply_to_screen_name", "screen_name")
+
+		for _, entities := range []interface{}{tweet["entities"], nestedField(tweet, "extended_tweet", "entities")} {
+			mentions, _ := nestedField(entities, "user_mentions").([]interface{})
+			for _, mention := range mentions {
+				if mention, ok := mention.(map[string]interface{}); ok {
+					delete(mention, "id")
+					delete(mention, "name")
+					r.pseudonymizeField(mention, "id_str", "id")
+					r.pseudonymizeField(mention, "screen_name", "screen_name")
+				}
+			}
+		}
+
+		for _, entities := range []interface{}{
+			tweet["entities"],
+			tweet["extended_entities"],
+			nestedField(tweet, "extended_tweet", "entities"),
+			nestedField(tweet, "extended_tweet", "extended_entities"),
+		} {
+			r.redactURLEntities(nestedField(entities, "urls"))
+			r.redactURLEntities(nestedField(entities, "media"))
+		}
+
+		if permalink, ok := tweet["quoted_status_permalink"].(map[string]interface{}); ok {
+			r.redactStatusURLField(permalink, "expanded")
+			r.redactStatusURLField(permalink, "display")
+		}
+	}
+
+	r.redactTextField(tweet, "text")
+	r.redactTextField(tweet, "full_text")
+	if extendedTweet, ok := tweet["extended_tweet"].(map[string]interface{}); ok {
+		r.redactTextField(extendedTweet, "full_text")
+	}
+
+	if r.dropGeo {
+		delete(tweet, "coordinates")
+		delete(tweet, "geo")
+		delete(tweet, "place")
+		if user, ok := tweet["user"].(map[string]interface{}); ok {
+			delete(user, "location")
+		}
+	}
+
+	for _, nested := range []string{"retweeted_status", "quoted_status"} {
+		if nestedTweet, ok := tweet[nested].(map[string]interface{}); ok {
+			r.redactV1Tweet(nestedTweet)
+		}
+	}
+}
+
+func (r *tweetRedaction) redactV2Payload(payload map[string]interface{}) {
+	if data, ok := payload["data"].(map[string]interface{}); ok {
+		r.redactV2Tweet(data)
+	}
+
+	includes, ok := payload["includes"].(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	tweets, _ := includes["tweets"].([]interface{})
+	for _, tweet := range tweets {
+		if tweet, ok := tweet.(map[string]interface{}); ok {
+			r.redactV2Tweet(tweet)
+		}
+	}
+
+	users, _ := includes["users"].([]interface{})
+	for _, user := range users {
+		if user, ok := user.(map[string]interface{}); ok {
+			if r.salt != nil {
+				r.redactUser(user, v2UserFields, "id", "username")
+			}
+			if r.dropGeo {
+				delete(user, "location")
+			}
+		}
+	}
+
+	if r.dropGeo {
+		delete(includes, "places")
+	}
+}
+
+func (r *tweetRedaction) redactV2Tweet(tweet map[string]interface{}) {
+	if r.salt != nil {
+		r.pseudonymizeField(tweet, "author_id", "id")
+		r.pseudonymizeField(tweet, "in_reply_to_user_id", "id")
+
+		mentions, _ := nestedField(tweet, "entities", "mentions").([]interface{})
+		for _, mention := range mentions {
+			if mention, ok := mention.(map[string]interface{}); ok {
+				r.pseudonymizeField(mention, "id", "id")
+				r.pseudonymizeField(mention, "username", "screen_name")
+			}
+		}
+
+		r.redactURLEntities(nestedField(tweet, "entities", "urls"))
+	}
+
+	r.redactTextField(tweet, "text")
+
+	if r.dropGeo {
+		delete(tweet, "geo")
+	}
+}
+
+// Drops the user fields not in keep, and pseudonymizes the ID and screen name fields
+func (r *tweetRedaction) redactUser(user map[string]interface{}, keep map[string]bool, idField string, screenNameField string) {
+	for field := range user {
+		if !keep[field] {
+			delete(user, field)
+		}
+	}
+	r.pseudonymizeField(user, idField, "id")
+	r.pseudonymizeField(user, screenNameField, "screen_name")
+}
+
+func (r *tweetRedaction) pseudonymizeField(object map[string]interface{}, field string, kind string) {
+	if value, ok := object[field].(string); ok && value != "" {
+		object[field] = r.pseudonym(kind, value)
+	}
+}
+
+// Returns a stable pseudonym for a user ID or screen name, which can't be reversed without the salt.
+// Screen names are case-insensitive, so every casing has the same pseudonym.
+func (r *tweetRedaction) pseudonym(kind string, value string) string {
+	if kind == "screen_name" {
+		value = strings.ToLower(value)
+	}
+
+	mac := hmac.New(sha256.New, r.salt)
+	mac.Write([]byte(kind + ":" + value))
+	return hex.EncodeToString(mac.Sum(nil)[:pseudonymLength])
+}
+
+// Pseudonymizes the authors of links to tweets in URL and media entities
+func (r *tweetRedaction) redactURLEntities(entities interface{}) {
+	list, _ := entities.([]interface{})
+	for _, entity := range list {
+		if entity, ok := entity.(map[string]interface{}); ok {
+			r.redactStatusURLField(entity, "expanded_url")
+			r.redactStatusURLField(entity, "display_url")
+		}
+	}
+}
+
+func (r *tweetRedaction) redactStatusURLField(object map[string]interface{}, field string) {
+	if value, ok := object[field].(string); ok {
+		object[field] = r.redactStatusURLs(value)
+	}
+}
+
+// Replaces the screen names in links to tweets with their pseudonyms
+func (r *tweetRedaction) redactStatusURLs(text string) string {
+	return statusURLPattern.ReplaceAllStringFunc(text, func(statusURL string) string {
+		parts := statusURLPattern.FindStringSubmatch(statusURL)
+		return parts[1] + r.pseudonym("screen_name", parts[2]) + parts[3]
+	})
+}
+
+// Pseudonymizes mentions and links to tweets in the text, then strips or truncates it.
+// Entity indices into the text are left as is.
+func (r *tweetRedaction) redactTextField(object map[string]interface{}, field string) {
+	text, ok := object[field].(string)
+	if !ok {
+		return
+	}
+
+	if r.salt != nil {
+		text = mentionPattern.ReplaceAllStringFunc(text, func(mention string) string {
+			at := strings.LastIndexByte(mention, '@')
+			return mention[:at+1] + r.pseudonym("screen_name", mention[at+1:])
+		})
+		text = r.redactStatusURLs(text)
+	}
+
+	switch r.text {
+	case redactTextStrip:
+		text = ""
+	case redactTextTruncate:
+		if runes := []rune(text); len(runes) > r.textLength {
+			text = string(runes[:r.textLength])
+		}
+	}
+
+	object[field] = text
+}
+
+// Returns the value at the path of object keys, or nil if there is none
+func nestedField(value interface{}, path ...string) interface{} {
+	for _, key := range path {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
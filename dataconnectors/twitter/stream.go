@@ -0,0 +1,349 @@
+package twitter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StatusConnecting   string = "connecting"
+	StatusConnected    string = "connected"
+	StatusReconnecting string = "reconnecting"
+	StatusStopped      string = "stopped"
+
+	// Twitter sends keep-alives at least every 30 seconds, so a connection without data for 90 seconds has stalled
+	defaultStallTimeout = 90 * time.Second
+)
+
+// ConnectorStatus reports the health of the stream connection
+type ConnectorStatus struct {
+	State               string
+	ConsecutiveFailures int
+	LastError           string
+	LastErrorTime       time.Time
+	LastConnectTime     time.Time
+	NextRetryTime       time.Time
+	// The latest stall warning, sent when the client falls behind the stream
+	StallWarning     string
+	StallPercentFull int
+	StallWarningTime time.Time
+	// The latest disconnect message sent before Twitter closed the stream
+	LastDisconnect     string
+	LastDisconnectTime time.Time
+	// Progress of the backfill from the search API, empty if there is none
+	BackfillState  string
+	BackfillTweets int
+	BackfillError  string
+	// Live tweets dropped because the buffer filled up while the backfill ran
+	BackfillDropped int
+}
+
+// How to wait before reconnecting, following Twitter's rules for streaming clients
+type backoffKind int
+
+const (
+	// An established connection dropped, reconnect immediately
+	noBackoff backoffKind = iota
+	// TCP/IP errors back off linearly by 250ms, up to 16 seconds
+	networkBackoff
+	// HTTP errors back off exponentially from 5 seconds, up to 320 seconds
+	httpBackoff
+	// HTTP 420 and 429 back off exponentially from 1 minute
+	rateLimitBackoff
+	// Reconnecting won't help, e.g. the credentials were rejected
+	noReconnect
+)
+
+// Disconnect codes after which reconnecting would fail or fight another client:
+// a duplicate stream, revoked token and admin logout
+var fatalDisconnectCodes = map[int64]bool{
+	2: true,
+	6: true,
+	7: true,
+}
+
+// An error that ended, or prevented, a stream connection
+type streamError struct {
+	err     error
+	backoff backoffKind
+}
+
+func (e *streamError) Error() string {
+	return e.err.Error()
+}
+
+func (e *streamError) Unwrap() error {
+	return e.err
+}
+
+// Opens a stream connection, returning the response as is
+type streamOpener func(ctx context.Context) (*http.Response, error)
+
+// Handles a message from the stream. Returning an error closes the connection.
+type messageHandler func(line []byte) error
+
+// Consecutive failures of each kind, reset once a connection receives data
+type reconnectBackoff struct {
+	failures map[backoffKind]int
+}
+
+func (b *reconnectBackoff) next(kind backoffKind) time.Duration {
+	if b.failures == nil {
+		b.failures = make(map[backoffKind]int)
+	}
+	b.failures[kind]++
+	n := b.failures[kind]
+
+	switch kind {
+	case networkBackoff:
+		return minDuration(time.Duration(n)*250*time.Millisecond, 16*time.Second)
+	case httpBackoff:
+		return 5 * time.Second << uint(minInt(n-1, 6))
+	case rateLimitBackoff:
+		// Twitter sets no upper bound, cap the doubling at 16 minutes
+		return time.Minute << uint(minInt(n-1, 4))
+	}
+	return 0
+}
+
+func (b *reconnectBackoff) reset() {
+	b.failures = nil
+}
+
+func parseStallTimeout(params map[string]string) (time.Duration, error) {
+	stallTimeout, ok := params["stall_timeout"]
+	if !ok {
+		return defaultStallTimeout, nil
+	}
+
+	st, err := time.ParseDuration(stallTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid stall_timeout '%s': %s", stallTimeout, err)
+	}
+	if st <= 0 {
+		return 0, fmt.Errorf("invalid stall_timeout '%s': timeout must be > 0", stallTimeout)
+	}
+	return st, nil
+}
+
+// Status returns the current state of the stream connection, including any reconnect in progress
+func (c *TwitterConnector) Status() ConnectorStatus {
+	c.statusMutex.RLock()
+	defer c.statusMutex.RUnlock()
+	return c.status
+}
+
+// Makes the first connection, returning its error so Init can fail, then reads the stream
+// in the background, reconnecting whenever the connection drops
+func (c *TwitterConnector) startStream(open streamOpener, handle messageHandler) error {
+	body, err := c.connect(open)
+	if err != nil {
+		c.recordFailure(err)
+		c.recordStopped()
+		return err
+	}
+
+	go c.readStream(body, open, handle)
+
+	return nil
+}
+
+func (c *TwitterConnector) connect(open streamOpener) (io.ReadCloser, error) {
+	c.statusMutex.Lock()
+	if c.status.State != StatusReconnecting {
+		c.status.State = StatusConnecting
+	}
+	c.statusMutex.Unlock()
+
+	resp, err := open(context.Background())
+	if err != nil {
+		return nil, &streamError{err: err, backoff: networkBackoff}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		respData, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		err := responseError(resp.Request.Method, resp.Request.URL.Path, resp, respData)
+
+		switch {
+		case resp.StatusCode == 420 || resp.StatusCode == http.StatusTooManyRequests:
+			return nil, &streamError{err: err, backoff: rateLimitBackoff}
+		case resp.StatusCode >= 500:
+			return nil, &streamError{err: err, backoff: httpBackoff}
+		}
+		return nil, &streamError{err: err, backoff: noReconnect}
+	}
+
+	c.statusMutex.Lock()
+	c.status.State = StatusConnected
+	c.status.ConsecutiveFailures = 0
+	c.status.LastConnectTime = time.Now()
+	c.status.NextRetryTime = time.Time{}
+	c.statusMutex.Unlock()
+
+	return resp.Body, nil
+}
+
+func (c *TwitterConnector) readStream(body io.ReadCloser, open streamOpener, handle messageHandler) {
+	backoff := &reconnectBackoff{}
+
+	for {
+		received, err := c.receive(body, handle)
+		if received {
+			backoff.reset()
+		}
+		c.recordFailure(err)
+
+		kind := err.backoff
+		if kind == noBackoff && !received {
+			// Don't spin on a server that accepts then closes every connection
+			kind = networkBackoff
+		}
+
+		for {
+			if kind == noReconnect {
+				log.Printf("twitter stream stopped: %s", err.Error())
+				c.recordStopped()
+				return
+			}
+
+			delay := backoff.next(kind)
+			log.Printf("twitter stream disconnected, reconnecting in %s: %s", delay, err.Error())
+
+			c.statusMutex.Lock()
+			c.status.NextRetryTime = time.Now().Add(delay)
+			c.statusMutex.Unlock()
+
+			time.Sleep(delay)
+
+			var connectErr error
+			body, connectErr = c.connect(open)
+			if connectErr == nil {
+				log.Println("twitter stream reconnected")
+				break
+			}
+
+			err = connectErr.(*streamError)
+			c.recordFailure(err)
+			kind = err.backoff
+		}
+	}
+}
+
+// Reads messages until the connection drops, stalls or handle fails.
+// Returns whether any data, including keep-alives, was received.
+func (c *TwitterConnector) receive(body io.ReadCloser, handle messageHandler) (bool, *streamError) {
+	defer body.Close()
+
+	var stalled int32
+	stallTimer := time.AfterFunc(c.stallTimeout, func() {
+		atomic.StoreInt32(&stalled, 1)
+		body.Close()
+	})
+	defer stallTimer.Stop()
+
+	received := false
+	reader := bufio.NewReader(body)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && atomic.LoadInt32(&stalled) == 0 {
+			stallTimer.Reset(c.stallTimeout)
+			received = true
+		}
+
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			handleErr := handle(line)
+			if handleErr != nil {
+				var streamErr *streamError
+				if errors.As(handleErr, &streamErr) {
+					return received, streamErr
+				}
+				return received, &streamError{err: handleErr, backoff: noBackoff}
+			}
+		}
+
+		if err != nil {
+			if atomic.LoadInt32(&stalled) == 1 {
+				return received, &streamError{err: fmt.Errorf("stream stalled, no data received for %s", c.stallTimeout), backoff: noBackoff}
+			}
+			if err == io.EOF {
+				return received, &streamError{err: errors.New("stream closed by twitter"), backoff: noBackoff}
+			}
+			return received, &streamError{err: err, backoff: noBackoff}
+		}
+	}
+}
+
+func (c *TwitterConnector) recordFailure(err error) {
+	c.statusMutex.Lock()
+	defer c.statusMutex.Unlock()
+
+	c.status.State = StatusReconnecting
+	c.status.ConsecutiveFailures++
+	c.status.LastError = err.Error()
+	c.status.LastErrorTime = time.Now()
+}
+
+func (c *TwitterConnector) recordStopped() {
+	c.statusMutex.Lock()
+	defer c.statusMutex.Unlock()
+
+	c.status.State = StatusStopped
+	c.status.NextRetryTime = time.Time{}
+}
+
+func (c *TwitterConnector) recordStallWarning(message string, percentFull int) {
+	log.Printf("twitter stream stall warning (%d%% full): %s", percentFull, message)
+
+	c.statusMutex.Lock()
+	defer c.statusMutex.Unlock()
+
+	c.status.StallWarning = message
+	c.status.StallPercentFull = percentFull
+	c.status.StallWarningTime = time.Now()
+}
+
+// Records a disconnect message, Twitter closes the connection after sending it
+func (c *TwitterConnector) handleDisconnect(code int64, reason string) error {
+	disconnect := fmt.Sprintf("%s (code %d)", reason, code)
+	c.recordDisconnect(disconnect)
+
+	err := fmt.Errorf("disconnected by twitter: %s", disconnect)
+	if fatalDisconnectCodes[code] {
+		return &streamError{err: err, backoff: noReconnect}
+	}
+	return err
+}
+
+func (c *TwitterConnector) recordDisconnect(reason string) {
+	log.Printf("twitter stream disconnect: %s", reason)
+
+	c.statusMutex.Lock()
+	defer c.statusMutex.Unlock()
+
+	c.status.LastDisconnect = reason
+	c.status.LastDisconnectTime = time.Now()
+}
+
+func minDuration(a time.Duration, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -6,6 +6,9 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/dghubble/go-twitter/twitter"
@@ -16,18 +19,67 @@ import (
 
 const (
 	TwitterConnectorName string = "twitter"
+
+	filterStreamURL string = "https://stream.twitter.com/1.1/statuses/filter.json"
 )
 
 type TwitterConnector struct {
-	client       *twitter.Client
+	httpClient   *http.Client
+	apiURL       string
+	v2           *v2Client
+	filter       *streamFilter
+	stallTimeout time.Duration
+	batch        *tweetBatch
+	backfill     *tweetBackfill
+	redaction    *tweetRedaction
 	readHandlers []*func(data []byte, metadata map[string]string) ([]byte, error)
+
+	statusMutex sync.RWMutex
+	status      ConnectorStatus
 }
 
 func NewTwitterConnector() *TwitterConnector {
-	return &TwitterConnector{}
+	return &TwitterConnector{
+		status: ConnectorStatus{State: StatusConnecting},
+	}
 }
 
 func (c *TwitterConnector) Init(epoch time.Time, period time.Duration, interval time.Duration, params map[string]string) error {
+	stallTimeout, err := parseStallTimeout(params)
+	if err != nil {
+		return err
+	}
+	c.stallTimeout = stallTimeout
+
+	batch, err := parseTweetBatch(params, interval)
+	if err != nil {
+		return err
+	}
+	if batch != nil {
+		batch.send = c.sendPayload
+	}
+	c.batch = batch
+
+	backfill, err := parseTweetBackfill(params, epoch, period)
+	if err != nil {
+		return err
+	}
+	c.backfill = backfill
+
+	redaction, err := parseTweetRedaction(params)
+	if err != nil {
+		return err
+	}
+	c.redaction = redaction
+
+	switch apiVersion := params["api_version"]; apiVersion {
+	case "", "1.1":
+	case apiVersion2:
+		return c.initV2(params)
+	default:
+		return fmt.Errorf("invalid api_version '%s': must be 1.1 or 2", apiVersion)
+	}
+
 	ck := params["consumer_key"]
 	if ck == "" {
 		return errors.New("consumer_key is required")
@@ -48,34 +100,35 @@ func (c *TwitterConnector) Init(epoch time.Time, period time.Duration, interval
 		return errors.New("access_secret is required")
 	}
 
-	filter := params["filter"]
-	if filter == "" {
-		return errors.New("filter is required")
+	filter, err := parseStreamFilter(params)
+	if err != nil {
+		return err
+	}
+	c.filter = filter
+
+	apiURL, err := parseAPIURL(params)
+	if err != nil {
+		return err
 	}
+	c.apiURL = apiURL
 
 	config := oauth1.NewConfig(ck, cs)
 	token := oauth1.NewToken(at, as)
-	httpClient := config.Client(oauth1.NoContext, token)
+	c.SetHTTPClient(config.Client(oauth1.NoContext, token))
 
-	// Twitter client
-	c.client = twitter.NewClient(httpClient)
-
-	demux := twitter.NewSwitchDemux()
-	demux.Tweet = func(tweet *twitter.Tweet) {
-		c.sendData(tweet)
+	if c.backfill != nil {
+		c.backfill.begin()
 	}
 
-	filterParams := &twitter.StreamFilterParams{
-		Track:         []string{filter},
-		StallWarnings: twitter.Bool(true),
-	}
-	stream, err := c.client.Streams.Filter(filterParams)
+	err = c.startStream(c.openFilterStream, c.handleMessage)
 	if err != nil {
-		log.Fatalln(err.Error())
+		return fmt.Errorf("failed to open twitter stream: %w", err)
 	}
 	log.Println(aurora.Green(fmt.Sprintf("started reading twitter stream with filter: %s", filter)))
 
-	go demux.HandleChan(stream.Messages)
+	if c.backfill != nil {
+		go c.runBackfill(c.searchV1)
+	}
 
 	return nil
 }
@@ -85,27 +138,119 @@ func (c *TwitterConnector) Read(handler func(data []byte, metadata map[string]st
 	return nil
 }
 
-func (c *TwitterConnector) sendData(tweets ...*twitter.Tweet) {
+// SetHTTPClient overrides the OAuth1 client used to reach Twitter
+func (c *TwitterConnector) SetHTTPClient(httpClient *http.Client) {
+	if c.httpClient == nil {
+		c.httpClient = httpClient
+	}
+}
+
+func (c *TwitterConnector) openFilterStream(ctx context.Context) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, filterStreamURL+"?"+c.filter.streamQuery().Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.httpClient.Do(req)
+}
+
+// Handles a v1.1 stream message. Message types have no type field, so they are told apart by their keys.
+func (c *TwitterConnector) handleMessage(line []byte) error {
+	var message map[string]json.RawMessage
+	err := json.Unmarshal(line, &message)
+	if err != nil {
+		log.Printf("invalid twitter stream message: %s", err.Error())
+		return nil
+	}
+
+	if _, ok := message["retweet_count"]; ok {
+		tweet := &twitter.Tweet{}
+		err = json.Unmarshal(line, tweet)
+		if err != nil {
+			log.Printf("invalid tweet: %s", err.Error())
+			return nil
+		}
+
+		tweetJson, err := json.Marshal(tweet)
+		if err != nil {
+			log.Println(err.Error())
+			return nil
+		}
+
+		c.sendTweet("tweet", tweet.IDStr, tweetJson, c.filter.matchedRules(tweet))
+		return nil
+	}
+
+	if warning, ok := message["warning"]; ok {
+		stallWarning := &twitter.StallWarning{}
+		if json.Unmarshal(warning, stallWarning) == nil {
+			c.recordStallWarning(stallWarning.Message, stallWarning.PercentFull)
+		}
+		return nil
+	}
+
+	if disconnect, ok := message["disconnect"]; ok {
+		streamDisconnect := &twitter.StreamDisconnect{}
+		if json.Unmarshal(disconnect, streamDisconnect) != nil {
+			return nil
+		}
+		return c.handleDisconnect(streamDisconnect.Code, streamDisconnect.Reason)
+	}
+
+	return nil
+}
+
+// Delivers a live tweet, unless it is held back until the backfill completes
+func (c *TwitterConnector) sendTweet(dataType string, id string, tweet []byte, matchedRules []string) {
 	if len(c.readHandlers) == 0 {
 		// Nothing to read
 		return
 	}
 
-	metadata := map[string]string{}
-	metadata["type"] = "tweet"
+	if c.backfill != nil && c.backfill.hold(&pendingTweet{dataType: dataType, id: id, tweet: tweet, matchedRules: matchedRules}) {
+		return
+	}
 
-	errGroup, _ := errgroup.WithContext(context.Background())
+	c.deliverTweet(dataType, tweet, matchedRules)
+}
 
+// Delivers a JSON encoded tweet as a one element array, or adds it to the batch when batching.
+// Tweets are redacted first, so no personal data leaves the connector.
+func (c *TwitterConnector) deliverTweet(dataType string, tweet []byte, matchedRules []string) {
 	if len(c.readHandlers) == 0 {
+		// Nothing to read
 		return
 	}
 
-	data, err := json.Marshal(tweets)
-	if err != nil {
-		log.Println(err.Error())
+	if c.redaction != nil {
+		var err error
+		tweet, err = c.redaction.redact(dataType, tweet)
+		if err != nil {
+			// Dropped rather than delivered unredacted
+			log.Printf("failed to redact tweet: %s", err.Error())
+			return
+		}
+	}
+
+	if c.batch != nil {
+		c.batch.add(dataType, tweet, matchedRules)
+		return
+	}
+
+	metadata := map[string]string{}
+	metadata["type"] = dataType
+	metadata["matched_rules"] = strings.Join(matchedRules, ",")
+
+	c.sendPayload(tweetArray(tweet), metadata)
+}
+
+func (c *TwitterConnector) sendPayload(data []byte, metadata map[string]string) {
+	if len(c.readHandlers) == 0 {
+		// Nothing to read
 		return
 	}
 
+	errGroup, _ := errgroup.WithContext(context.Background())
+
 	for _, handler := range c.readHandlers {
 		readHandler := *handler
 		errGroup.Go(func() error {
@@ -114,7 +259,7 @@ func (c *TwitterConnector) sendData(tweets ...*twitter.Tweet) {
 		})
 	}
 
-	err = errGroup.Wait()
+	err := errGroup.Wait()
 	if err != nil {
 		log.Println(err.Error())
 	}
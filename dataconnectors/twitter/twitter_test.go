@@ -1,8 +1,17 @@
 package twitter_test
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -72,6 +81,1046 @@ func TestRead(t *testing.T) {
 	assert.Len(t, tweets, 5)
 }
 
+func TestFilters(t *testing.T) {
+	tweets := []*twitter.Tweet{
+		{
+			IDStr: "1",
+			Text:  "Bitcoin hits a new high",
+			User:  &twitter.User{IDStr: "100", ScreenName: "trader"},
+		},
+		{
+			IDStr: "2",
+			Text:  "Market update",
+			User:  &twitter.User{IDStr: "783214", ScreenName: "twitter"},
+			Entities: &twitter.Entities{
+				Hashtags: []twitter.HashtagEntity{{Text: "ethereum"}},
+			},
+		},
+		{
+			IDStr:       "3",
+			Text:        "Lunch in the city",
+			User:        &twitter.User{IDStr: "200", ScreenName: "local"},
+			Coordinates: &twitter.Coordinates{Coordinates: [2]float64{-122.4, 37.7}, Type: "Point"},
+		},
+		{
+			IDStr: "4",
+			Text:  "Retweeting the ETH merge news",
+			User:  &twitter.User{IDStr: "300", ScreenName: "fan"},
+			RetweetedStatus: &twitter.Tweet{
+				Text: "The merge is coming",
+				User: &twitter.User{IDStr: "783214", ScreenName: "twitter"},
+			},
+			Place: &twitter.Place{BoundingBox: &twitter.BoundingBox{
+				Coordinates: [][][2]float64{{{-122.5, 37.6}, {-122.3, 37.6}, {-122.3, 37.8}, {-122.5, 37.8}}},
+			}},
+		},
+	}
+
+	var streamQuery url.Values
+	httpClient := newMockStream(t, func(w http.ResponseWriter, r *http.Request) {
+		streamQuery = r.URL.Query()
+		for _, tweet := range tweets {
+			tweetJson, err := json.Marshal(tweet)
+			assert.NoError(t, err)
+			_, _ = w.Write(append(tweetJson, '\r', '\n'))
+		}
+	})
+
+	c := spice_twitter.NewTwitterConnector()
+	c.SetHTTPClient(httpClient)
+
+	var epoch time.Time
+	var period time.Duration
+	var interval time.Duration
+
+	params := getAuthParams()
+	params["track"] = "bitcoin,eth merge,#ethereum"
+	params["follow"] = "783214"
+	params["locations"] = "-122.75,36.8,-121.75,37.8"
+	params["language"] = "en,es"
+	params["filter_level"] = "low"
+
+	wg := sync.WaitGroup{}
+	wg.Add(len(tweets))
+
+	mutex := sync.Mutex{}
+	matchedRules := map[string]string{}
+
+	err := c.Read(func(data []byte, metadata map[string]string) ([]byte, error) {
+		var tweets []*twitter.Tweet
+		err := json.Unmarshal(data, &tweets)
+		if assert.NoError(t, err) && assert.Len(t, tweets, 1) {
+			mutex.Lock()
+			matchedRules[tweets[0].IDStr] = metadata["matched_rules"]
+			mutex.Unlock()
+		}
+		wg.Done()
+		return nil, nil
+	})
+	assert.NoError(t, err)
+
+	err = c.Init(epoch, period, interval, params)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, "bitcoin,eth merge,#ethereum", streamQuery.Get("track"))
+	assert.Equal(t, "783214", streamQuery.Get("follow"))
+	assert.Equal(t, "-122.75,36.8,-121.75,37.8", streamQuery.Get("locations"))
+	assert.Equal(t, "en,es", streamQuery.Get("language"))
+	assert.Equal(t, "low", streamQuery.Get("filter_level"))
+
+	assert.Equal(t, map[string]string{
+		"1": "track:bitcoin",
+		"2": "track:#ethereum,follow:783214",
+		"3": "locations:-122.75,36.8,-121.75,37.8",
+		"4": "track:eth merge,follow:783214,locations:-122.75,36.8,-121.75,37.8",
+	}, matchedRules)
+
+	invalidParams := []map[string]string{
+		{},
+		{"follow": "@twitter"},
+		{"locations": "-122.75,36.8,-121.75"},
+		{"locations": "-122.75,36.8,-121.75,137.8"},
+		{"track": "bitcoin", "filter_level": "high"},
+	}
+	for _, invalid := range invalidParams {
+		params := getAuthParams()
+		for key, value := range invalid {
+			params[key] = value
+		}
+		err := spice_twitter.NewTwitterConnector().Init(epoch, period, interval, params)
+		assert.Error(t, err)
+	}
+}
+
+func TestBatching(t *testing.T) {
+	tweets := []*twitter.Tweet{
+		{IDStr: "1", Text: "Bitcoin hits a new high"},
+		{IDStr: "2", Text: "Ethereum too"},
+		{IDStr: "3", Text: "Bitcoin and ethereum"},
+		{IDStr: "4", Text: "Bitcoin again"},
+		{IDStr: "5", Text: "Ethereum again"},
+	}
+
+	httpClient := newMockStream(t, func(w http.ResponseWriter, r *http.Request) {
+		for _, tweet := range tweets {
+			tweetJson, err := json.Marshal(tweet)
+			assert.NoError(t, err)
+			_, _ = w.Write(append(tweetJson, '\r', '\n'))
+		}
+	})
+
+	c := spice_twitter.NewTwitterConnector()
+	c.SetHTTPClient(httpClient)
+
+	var epoch time.Time
+	var period time.Duration
+	interval := 200 * time.Millisecond
+
+	params := getAuthParams()
+	params["track"] = "bitcoin,ethereum"
+	params["batch_size"] = "2"
+
+	wg := sync.WaitGroup{}
+	wg.Add(3)
+
+	mutex := sync.Mutex{}
+	var batches [][]string
+	var batchMetadata []map[string]string
+
+	err := c.Read(func(data []byte, metadata map[string]string) ([]byte, error) {
+		var tweets []*twitter.Tweet
+		err := json.Unmarshal(data, &tweets)
+		if assert.NoError(t, err) {
+			var ids []string
+			for _, tweet := range tweets {
+				ids = append(ids, tweet.IDStr)
+			}
+			mutex.Lock()
+			batches = append(batches, ids)
+			batchMetadata = append(batchMetadata, metadata)
+			mutex.Unlock()
+		}
+		wg.Done()
+		return nil, nil
+	})
+	assert.NoError(t, err)
+
+	err = c.Init(epoch, period, interval, params)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	wg.Wait()
+
+	// The last tweet is delivered once the interval passes
+	assert.Equal(t, [][]string{{"1", "2"}, {"3", "4"}, {"5"}}, batches)
+
+	assert.Equal(t, "tweet", batchMetadata[0]["type"])
+	assert.Equal(t, "track:bitcoin,track:ethereum", batchMetadata[1]["matched_rules"])
+	assert.Equal(t, "track:ethereum", batchMetadata[2]["matched_rules"])
+	assert.Equal(t, "2", batchMetadata[0]["batch_count"])
+	assert.Equal(t, "1", batchMetadata[2]["batch_count"])
+
+	batchStart, err := time.Parse(time.RFC3339Nano, batchMetadata[2]["batch_start"])
+	assert.NoError(t, err)
+	batchEnd, err := time.Parse(time.RFC3339Nano, batchMetadata[2]["batch_end"])
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, int64(batchEnd.Sub(batchStart)), int64(interval))
+
+	invalidParams := []map[string]string{
+		{"batch_size": "0"},
+		{"batch_size": "many"},
+		{"batch_interval": "-1s"},
+	}
+	for _, invalid := range invalidParams {
+		params := getAuthParams()
+		params["track"] = "bitcoin"
+		for key, value := range invalid {
+			params[key] = value
+		}
+		err := spice_twitter.NewTwitterConnector().Init(epoch, period, interval, params)
+		assert.Error(t, err)
+	}
+
+	// Without a dataspace interval, batch_interval is required
+	params = getAuthParams()
+	params["track"] = "bitcoin"
+	params["batch_size"] = "10"
+	err = spice_twitter.NewTwitterConnector().Init(epoch, period, 0, params)
+	assert.Error(t, err)
+}
+
+func TestBackfill(t *testing.T) {
+	now := time.Now()
+	epoch := now.Add(-2 * time.Hour)
+	period := time.Hour
+	var interval time.Duration
+
+	newTweet := func(id string, age time.Duration, text string) *twitter.Tweet {
+		return &twitter.Tweet{IDStr: id, Text: text, CreatedAt: now.Add(-age).Format(time.RubyDate)}
+	}
+
+	t.Run("v1.1 search", func(t *testing.T) {
+		var searchQueries []url.Values
+		var searchRequests int32
+
+		done := make(chan bool)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/1.1/statuses/filter.json":
+				// A live tweet, and one the backfill also found
+				for _, tweet := range []*twitter.Tweet{newTweet("105", 0, "bitcoin live"), newTweet("104", 70*time.Minute, "bitcoin")} {
+					tweetJson, err := json.Marshal(tweet)
+					assert.NoError(t, err)
+					_, _ = w.Write(append(tweetJson, '\r', '\n'))
+				}
+				w.(http.Flusher).Flush()
+				select {
+				case <-done:
+				case <-r.Context().Done():
+				}
+			case "/1.1/search/tweets.json":
+				if atomic.AddInt32(&searchRequests, 1) == 1 {
+					// Give the live tweets time to arrive, then rate limit
+					time.Sleep(100 * time.Millisecond)
+					w.Header().Set("x-rate-limit-reset", strconv.FormatInt(time.Now().Unix(), 10))
+					w.WriteHeader(http.StatusTooManyRequests)
+					return
+				}
+
+				searchQueries = append(searchQueries, r.URL.Query())
+
+				var statuses []*twitter.Tweet
+				if r.URL.Query().Get("max_id") == "" {
+					statuses = []*twitter.Tweet{
+						newTweet("106", 30*time.Minute, "bitcoin after the window"),
+						newTweet("104", 70*time.Minute, "bitcoin"),
+						newTweet("103", 80*time.Minute, "the eth merge"),
+					}
+				} else {
+					statuses = []*twitter.Tweet{
+						newTweet("102", 100*time.Minute, "bitcoin"),
+						newTweet("101", 3*time.Hour, "bitcoin before the window"),
+					}
+				}
+				err := json.NewEncoder(w).Encode(map[string]interface{}{"statuses": statuses})
+				assert.NoError(t, err)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		t.Cleanup(func() {
+			close(done)
+			server.Close()
+		})
+
+		serverURL, err := url.Parse(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := spice_twitter.NewTwitterConnector()
+		c.SetHTTPClient(&http.Client{Transport: &redirectTransport{target: serverURL}})
+
+		params := getAuthParams()
+		params["track"] = "bitcoin,eth merge"
+		params["api_url"] = server.URL
+		params["backfill"] = "true"
+		// Only room for the first live tweet, the duplicate is dropped
+		params["backfill_buffer"] = "1"
+
+		mutex := sync.Mutex{}
+		var received []string
+		matchedRules := map[string]string{}
+
+		err = c.Read(func(data []byte, metadata map[string]string) ([]byte, error) {
+			var tweets []*twitter.Tweet
+			err := json.Unmarshal(data, &tweets)
+			if assert.NoError(t, err) && assert.Len(t, tweets, 1) {
+				mutex.Lock()
+				received = append(received, tweets[0].IDStr)
+				matchedRules[tweets[0].IDStr] = metadata["matched_rules"]
+				mutex.Unlock()
+			}
+			return nil, nil
+		})
+		assert.NoError(t, err)
+
+		err = c.Init(epoch, period, interval, params)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.Eventually(t, func() bool {
+			return c.Status().BackfillState == spice_twitter.BackfillComplete
+		}, 5*time.Second, 10*time.Millisecond)
+
+		// History first, then the live stream without the duplicate
+		mutex.Lock()
+		assert.Equal(t, []string{"104", "103", "102", "105"}, received)
+		assert.Equal(t, "track:eth merge", matchedRules["103"])
+		mutex.Unlock()
+
+		assert.Equal(t, 3, c.Status().BackfillTweets)
+		assert.Equal(t, 1, c.Status().BackfillDropped)
+
+		if assert.Len(t, searchQueries, 2) {
+			assert.Equal(t, "bitcoin OR (eth merge)", searchQueries[0].Get("q"))
+			assert.Equal(t, "102", searchQueries[1].Get("max_id"))
+		}
+	})
+
+	t.Run("v2 recent search", func(t *testing.T) {
+		var searchQueries []url.Values
+
+		done := make(chan bool)
+		mux := http.NewServeMux()
+		mux.HandleFunc("/2/tweets/search/stream/rules", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"meta":{}}`))
+		})
+		mux.HandleFunc("/2/tweets/search/stream", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"data":{"id":"5","text":"bitcoin live"},"matching_rules":[{"id":"1","tag":"spice/track:bitcoin"}]}` + "\r\n"))
+			w.(http.Flusher).Flush()
+			select {
+			case <-done:
+			case <-r.Context().Done():
+			}
+		})
+		mux.HandleFunc("/2/tweets/search/recent", func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+			searchQueries = append(searchQueries, query)
+
+			switch {
+			case query.Get("query") == "bitcoin" && query.Get("next_token") == "":
+				_, _ = w.Write([]byte(`{"data":[{"id":"3","text":"bitcoin","author_id":"100"}],"includes":{"users":[{"id":"100","username":"trader"},{"id":"200","username":"other"}]},"meta":{"next_token":"page2"}}`))
+			case query.Get("query") == "bitcoin":
+				_, _ = w.Write([]byte(`{"data":[{"id":"2","text":"bitcoin and ethereum","author_id":"200"}],"meta":{}}`))
+			default:
+				_, _ = w.Write([]byte(`{"data":[{"id":"2","text":"bitcoin and ethereum"},{"id":"1","text":"ethereum"}],"meta":{}}`))
+			}
+		})
+		server := httptest.NewServer(mux)
+		t.Cleanup(func() {
+			close(done)
+			server.Close()
+		})
+
+		c := spice_twitter.NewTwitterConnector()
+
+		params := map[string]string{
+			"api_version":  "2",
+			"api_url":      server.URL,
+			"bearer_token": "test_token",
+			"track":        "bitcoin,ethereum",
+			"backfill":     "true",
+		}
+
+		mutex := sync.Mutex{}
+		var received []string
+		payloads := map[string]map[string]interface{}{}
+
+		err := c.Read(func(data []byte, metadata map[string]string) ([]byte, error) {
+			var tweets []map[string]interface{}
+			err := json.Unmarshal(data, &tweets)
+			if assert.NoError(t, err) && assert.Len(t, tweets, 1) {
+				id := tweets[0]["data"].(map[string]interface{})["id"].(string)
+				mutex.Lock()
+				received = append(received, id)
+				payloads[id] = tweets[0]
+				mutex.Unlock()
+			}
+			return nil, nil
+		})
+		assert.NoError(t, err)
+
+		err = c.Init(epoch, period, interval, params)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.Eventually(t, func() bool {
+			return c.Status().BackfillState == spice_twitter.BackfillComplete
+		}, 5*time.Second, 10*time.Millisecond)
+
+		mutex.Lock()
+		assert.Equal(t, []string{"3", "2", "1", "5"}, received)
+		assert.Equal(t, map[string]interface{}{
+			"users": []interface{}{map[string]interface{}{"id": "100", "username": "trader"}},
+		}, payloads["3"]["includes"])
+		assert.Equal(t, []interface{}{map[string]interface{}{"value": "ethereum", "tag": "track:ethereum"}}, payloads["1"]["matching_rules"])
+		mutex.Unlock()
+
+		if assert.Len(t, searchQueries, 3) {
+			assert.Equal(t, epoch.UTC().Format(time.RFC3339), searchQueries[0].Get("start_time"))
+			assert.Equal(t, epoch.Add(period).UTC().Format(time.RFC3339), searchQueries[0].Get("end_time"))
+			assert.Equal(t, "page2", searchQueries[1].Get("next_token"))
+			assert.Equal(t, "ethereum", searchQueries[2].Get("query"))
+		}
+	})
+
+	t.Run("no backfill unless enabled", func(t *testing.T) {
+		var searchRequests int32
+
+		done := make(chan bool)
+		mux := http.NewServeMux()
+		mux.HandleFunc("/2/tweets/search/stream/rules", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"meta":{}}`))
+		})
+		mux.HandleFunc("/2/tweets/search/stream", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"data":{"id":"5","text":"bitcoin live"},"matching_rules":[{"id":"1","tag":"spice/track:bitcoin"}]}` + "\r\n"))
+			w.(http.Flusher).Flush()
+			select {
+			case <-done:
+			case <-r.Context().Done():
+			}
+		})
+		mux.HandleFunc("/2/tweets/search/recent", func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&searchRequests, 1)
+			_, _ = w.Write([]byte(`{"meta":{}}`))
+		})
+		server := httptest.NewServer(mux)
+		t.Cleanup(func() {
+			close(done)
+			server.Close()
+		})
+
+		c := spice_twitter.NewTwitterConnector()
+
+		received := make(chan bool, 1)
+		err := c.Read(func(data []byte, metadata map[string]string) ([]byte, error) {
+			received <- true
+			return nil, nil
+		})
+		assert.NoError(t, err)
+
+		err = c.Init(epoch, period, interval, map[string]string{
+			"api_version":  "2",
+			"api_url":      server.URL,
+			"bearer_token": "test_token",
+			"track":        "bitcoin",
+		})
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		// The live tweet is delivered straight away, without searching the window first
+		select {
+		case <-received:
+		case <-time.After(5 * time.Second):
+			t.Fatal("live tweet not delivered")
+		}
+		assert.Equal(t, int32(0), atomic.LoadInt32(&searchRequests))
+		assert.Equal(t, "", c.Status().BackfillState)
+	})
+
+	t.Run("Init() with invalid backfill", func(t *testing.T) {
+		invalidParams := []map[string]string{
+			{"backfill": "sometimes"},
+			{"backfill_buffer": "0"},
+			{"backfill_buffer": "lots"},
+			{"api_url": "api.twitter.com"},
+		}
+		for _, invalid := range invalidParams {
+			params := getAuthParams()
+			params["track"] = "bitcoin"
+			for key, value := range invalid {
+				params[key] = value
+			}
+			err := spice_twitter.NewTwitterConnector().Init(epoch, period, interval, params)
+			assert.Error(t, err, invalid)
+		}
+	})
+}
+
+func TestStreamReconnect(t *testing.T) {
+	tweets := []*twitter.Tweet{
+		{IDStr: "1", Text: "Bitcoin hits a new high"},
+		{IDStr: "2", Text: "Bitcoin falls back"},
+	}
+
+	var connections int32
+	done := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&connections, 1) {
+		case 1:
+			// Falls behind, then is disconnected
+			tweetJson, err := json.Marshal(tweets[0])
+			assert.NoError(t, err)
+			_, _ = w.Write(append(tweetJson, '\r', '\n'))
+			_, _ = w.Write([]byte(`{"warning":{"code":"FALLING_BEHIND","message":"Your connection is falling behind","percent_full":60}}` + "\r\n"))
+			_, _ = w.Write([]byte(`{"disconnect":{"code":10,"stream_name":"test","reason":"stream exception"}}` + "\r\n"))
+		case 2:
+			// Stalls
+			tweetJson, err := json.Marshal(tweets[1])
+			assert.NoError(t, err)
+			_, _ = w.Write(append(tweetJson, '\r', '\n'))
+			w.(http.Flusher).Flush()
+			select {
+			case <-done:
+			case <-r.Context().Done():
+			}
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"errors":[{"message":"Unauthorized"}]}`))
+		}
+	}))
+	t.Cleanup(func() {
+		close(done)
+		server.Close()
+	})
+
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := spice_twitter.NewTwitterConnector()
+	c.SetHTTPClient(&http.Client{Transport: &redirectTransport{target: serverURL}})
+
+	var epoch time.Time
+	var period time.Duration
+	var interval time.Duration
+
+	params := getAuthParams()
+	params["track"] = "bitcoin"
+	params["stall_timeout"] = "200ms"
+
+	mutex := sync.Mutex{}
+	var received []string
+
+	err = c.Read(func(data []byte, metadata map[string]string) ([]byte, error) {
+		var tweets []*twitter.Tweet
+		err := json.Unmarshal(data, &tweets)
+		if assert.NoError(t, err) && assert.Len(t, tweets, 1) {
+			mutex.Lock()
+			received = append(received, tweets[0].IDStr)
+			mutex.Unlock()
+		}
+		return nil, nil
+	})
+	assert.NoError(t, err)
+
+	err = c.Init(epoch, period, interval, params)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Eventually(t, func() bool {
+		return c.Status().State == spice_twitter.StatusStopped
+	}, 5*time.Second, 10*time.Millisecond)
+
+	mutex.Lock()
+	assert.Equal(t, []string{"1", "2"}, received)
+	mutex.Unlock()
+
+	status := c.Status()
+	assert.Equal(t, int32(3), atomic.LoadInt32(&connections))
+	assert.Equal(t, "Your connection is falling behind", status.StallWarning)
+	assert.Equal(t, 60, status.StallPercentFull)
+	assert.Equal(t, "stream exception (code 10)", status.LastDisconnect)
+	assert.Contains(t, status.LastError, "401 Unauthorized")
+
+	t.Run("Init() fails when the stream can't be opened", func(t *testing.T) {
+		c := spice_twitter.NewTwitterConnector()
+		c.SetHTTPClient(&http.Client{Transport: &redirectTransport{target: serverURL}})
+
+		err := c.Init(epoch, period, interval, params)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "401 Unauthorized")
+		}
+		assert.Equal(t, spice_twitter.StatusStopped, c.Status().State)
+	})
+
+	t.Run("backs off when connections close without data", func(t *testing.T) {
+		var connectTimes []time.Time
+		connectMutex := sync.Mutex{}
+		emptyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			connectMutex.Lock()
+			connectTimes = append(connectTimes, time.Now())
+			n := len(connectTimes)
+			connectMutex.Unlock()
+
+			if n > 3 {
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte(`{"errors":[{"message":"Unauthorized"}]}`))
+			}
+			// Otherwise accepted, then closed without sending anything
+		}))
+		defer emptyServer.Close()
+
+		emptyServerURL, err := url.Parse(emptyServer.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := spice_twitter.NewTwitterConnector()
+		c.SetHTTPClient(&http.Client{Transport: &redirectTransport{target: emptyServerURL}})
+
+		err = c.Init(epoch, period, interval, params)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.Eventually(t, func() bool {
+			return c.Status().State == spice_twitter.StatusStopped
+		}, 5*time.Second, 10*time.Millisecond)
+
+		connectMutex.Lock()
+		defer connectMutex.Unlock()
+		if assert.Len(t, connectTimes, 4) {
+			// Network backoff grows by 250ms per empty connection, 250ms, 500ms then 750ms
+			assert.GreaterOrEqual(t, int64(connectTimes[3].Sub(connectTimes[2])), int64(700*time.Millisecond))
+		}
+	})
+
+	t.Run("Init() with invalid stall_timeout", func(t *testing.T) {
+		params := getAuthParams()
+		params["track"] = "bitcoin"
+		params["stall_timeout"] = "0s"
+
+		err := spice_twitter.NewTwitterConnector().Init(epoch, period, interval, params)
+		assert.Error(t, err)
+	})
+}
+
+func TestV2Stream(t *testing.T) {
+	payloads := []string{
+		`{"data":{"id":"1","text":"Bitcoin hits a new high","created_at":"2021-10-06T23:04:56.000Z"},"matching_rules":[{"id":"10","tag":"spice/track:bitcoin"}]}`,
+		// Only matched another connector's rule
+		`{"data":{"id":"3","text":"Ethereum","created_at":"2021-10-06T23:05:00.000Z"},"matching_rules":[{"id":"8","tag":"other/track:ethereum"}]}`,
+		`{"data":{"id":"2","text":"Market update","created_at":"2021-10-06T23:05:10.000Z"},"matching_rules":[{"id":"12","tag":"spice/crypto news"},{"id":"8","tag":"other/track:ethereum"},{"id":"11","tag":"spice/follow:783214"}]}`,
+	}
+
+	var rulesMutex sync.Mutex
+	var ruleUpdates []map[string]interface{}
+	var streamQuery url.Values
+	var authorization string
+
+	done := make(chan bool)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/2/tweets/search/stream/rules", func(w http.ResponseWriter, r *http.Request) {
+		rulesMutex.Lock()
+		defer rulesMutex.Unlock()
+
+		authorization = r.Header.Get("Authorization")
+		if r.Method == http.MethodGet {
+			// Rules of other connectors on the same app are left alone
+			_, _ = w.Write([]byte(`{"data":[{"id":"10","value":"bitcoin","tag":"spice/track:bitcoin"},{"id":"9","value":"dogecoin","tag":"spice/track:dogecoin"},` +
+				`{"id":"8","value":"ethereum","tag":"other/track:ethereum"},{"id":"7","value":"cats"}]}`))
+			return
+		}
+
+		var update map[string]interface{}
+		err := json.NewDecoder(r.Body).Decode(&update)
+		assert.NoError(t, err)
+		ruleUpdates = append(ruleUpdates, update)
+		_, _ = w.Write([]byte(`{"meta":{}}`))
+	})
+	mux.HandleFunc("/2/tweets/search/stream", func(w http.ResponseWriter, r *http.Request) {
+		streamQuery = r.URL.Query()
+		for _, payload := range payloads {
+			_, _ = w.Write([]byte(payload + "\r\n"))
+			// Keep-alive
+			_, _ = w.Write([]byte("\r\n"))
+		}
+		w.(http.Flusher).Flush()
+		<-done
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		close(done)
+		server.Close()
+	})
+
+	c := spice_twitter.NewTwitterConnector()
+
+	var epoch time.Time
+	var period time.Duration
+	var interval time.Duration
+
+	params := map[string]string{
+		"api_version":  "2",
+		"api_url":      server.URL,
+		"bearer_token": "test_token",
+		"rules":        `[{"value":"from:783214 OR #crypto","tag":"crypto news"}]`,
+		"track":        "bitcoin",
+		"follow":       "783214",
+	}
+
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+
+	mutex := sync.Mutex{}
+	matchedRules := map[string]string{}
+	payloadRules := map[string]interface{}{}
+
+	err := c.Read(func(data []byte, metadata map[string]string) ([]byte, error) {
+		assert.Equal(t, "tweet_v2", metadata["type"])
+		var tweets []struct {
+			Data struct {
+				ID string `json:"id"`
+			} `json:"data"`
+			MatchingRules interface{} `json:"matching_rules"`
+		}
+		err := json.Unmarshal(data, &tweets)
+		if assert.NoError(t, err) && assert.Len(t, tweets, 1) {
+			mutex.Lock()
+			matchedRules[tweets[0].Data.ID] = metadata["matched_rules"]
+			payloadRules[tweets[0].Data.ID] = tweets[0].MatchingRules
+			mutex.Unlock()
+		}
+		wg.Done()
+		return nil, nil
+	})
+	assert.NoError(t, err)
+
+	err = c.Init(epoch, period, interval, params)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	wg.Wait()
+
+	rulesMutex.Lock()
+	assert.Equal(t, "Bearer test_token", authorization)
+	assert.Equal(t, []map[string]interface{}{
+		{"delete": map[string]interface{}{"ids": []interface{}{"9"}}},
+		{"add": []interface{}{
+			map[string]interface{}{"value": "from:783214 OR #crypto", "tag": "spice/crypto news"},
+			map[string]interface{}{"value": "from:783214", "tag": "spice/follow:783214"},
+		}},
+	}, ruleUpdates)
+	rulesMutex.Unlock()
+
+	assert.Equal(t, "author_id", streamQuery.Get("expansions"))
+
+	// The other connector's tweet came before tweet 2 on the stream, so it would have been delivered by now
+	mutex.Lock()
+	assert.Equal(t, map[string]string{
+		"1": "track:bitcoin",
+		"2": "crypto news,follow:783214",
+	}, matchedRules)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"id": "12", "tag": "crypto news"},
+		map[string]interface{}{"id": "11", "tag": "follow:783214"},
+	}, payloadRules["2"])
+	mutex.Unlock()
+
+	invalidParams := []map[string]string{
+		{"api_version": "3", "track": "bitcoin"},
+		{"api_version": "2", "track": "bitcoin"},
+		{"api_version": "2", "bearer_token": "test_token"},
+		{"api_version": "2", "bearer_token": "test_token", "rules": `[{"tag":"no value"}]`},
+		{"api_version": "2", "bearer_token": "test_token", "rules": "bitcoin"},
+		{"api_version": "2", "bearer_token": "test_token", "track": "bitcoin", "locations": "-122.75,36.8,-121.75,37.8"},
+		{"api_version": "2", "bearer_token": "test_token", "track": "bitcoin", "rule_namespace": "pod/a"},
+	}
+	for _, invalid := range invalidParams {
+		invalid["api_url"] = server.URL
+		err := spice_twitter.NewTwitterConnector().Init(epoch, period, interval, invalid)
+		assert.Error(t, err)
+	}
+}
+
+func TestRedaction(t *testing.T) {
+	salt := "a salt of at least 16 characters"
+	pseudonym := func(kind string, value string) string {
+		mac := hmac.New(sha256.New, []byte(salt))
+		mac.Write([]byte(kind + ":" + value))
+		return hex.EncodeToString(mac.Sum(nil)[:16])
+	}
+
+	var epoch time.Time
+	var period time.Duration
+	var interval time.Duration
+
+	// Reads the first delivered tweet as generic JSON, so dropped fields show as missing
+	readTweet := func(t *testing.T, c *spice_twitter.TwitterConnector) <-chan map[string]interface{} {
+		received := make(chan map[string]interface{}, 1)
+		err := c.Read(func(data []byte, metadata map[string]string) ([]byte, error) {
+			var tweets []map[string]interface{}
+			err := json.Unmarshal(data, &tweets)
+			if assert.NoError(t, err) && assert.Len(t, tweets, 1) {
+				select {
+				case received <- tweets[0]:
+				default:
+				}
+			}
+			return nil, nil
+		})
+		assert.NoError(t, err)
+		return received
+	}
+
+	t.Run("v1.1 tweet", func(t *testing.T) {
+		user := `{"id":783214,"id_str":"783214","screen_name":"Twitter","name":"Twitter","location":"San Francisco","description":"What's happening","profile_image_url_https":"https://pbs.twimg.com/profile.png","followers_count":5,"verified":true}`
+		tweet := `{"id":1,"id_str":"1","created_at":"Thu Sep 30 12:36:31 +0000 2021","text":"@Twitter great news from @jack today","lang":"en","retweet_count":0,` +
+			`"user":` + user + `,"in_reply_to_user_id":783214,"in_reply_to_user_id_str":"783214","in_reply_to_screen_name":"Twitter",` +
+			`"entities":{"user_mentions":[{"id":783214,"id_str":"783214","screen_name":"twitter","name":"Twitter","indices":[0,8]}],` +
+			`"urls":[{"url":"https://t.co/a","expanded_url":"https://twitter.com/Jack/status/20","display_url":"twitter.com/Jack/status/20"}]},` +
+			`"extended_entities":{"media":[{"url":"https://t.co/m","expanded_url":"https://twitter.com/Twitter/status/1/photo/1","display_url":"pic.twitter.com/m"}]},` +
+			`"quoted_status_permalink":{"url":"https://t.co/q","expanded":"https://twitter.com/jack/status/20","display":"twitter.com/jack/status/20"},` +
+			`"coordinates":{"type":"Point","coordinates":[-122.4,37.8]},"place":{"id":"5a110d312052166f","full_name":"San Francisco, CA"},` +
+			`"retweeted_status":{"id":2,"id_str":"2","text":"hi@example.com, @jack","retweet_count":1,"user":` + user + `}}`
+
+		httpClient := newMockStream(t, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(tweet + "\r\n"))
+		})
+
+		c := spice_twitter.NewTwitterConnector()
+		c.SetHTTPClient(httpClient)
+		received := readTweet(t, c)
+
+		params := getAuthParams()
+		params["track"] = "news"
+		params["redact_users"] = "true"
+		params["redact_salt"] = salt
+		params["redact_text"] = "truncate"
+		params["redact_text_length"] = "40"
+		params["redact_geo"] = "true"
+
+		err := c.Init(epoch, period, interval, params)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		var redacted map[string]interface{}
+		select {
+		case redacted = <-received:
+		case <-time.After(5 * time.Second):
+			t.Fatal("no tweet delivered")
+		}
+
+		expectedUser := map[string]interface{}{
+			"id_str":          pseudonym("id", "783214"),
+			"screen_name":     pseudonym("screen_name", "twitter"),
+			"followers_count": float64(5),
+			"verified":        true,
+		}
+		for field, value := range expectedUser {
+			assert.Equal(t, value, redacted["user"].(map[string]interface{})[field], field)
+		}
+		for _, field := range []string{"id", "name", "location", "description", "profile_image_url_https"} {
+			assert.NotContains(t, redacted["user"], field)
+		}
+
+		assert.Equal(t, "@"+pseudonym("screen_name", "twitter")+" great ", redacted["text"])
+		assert.Equal(t, pseudonym("id", "783214"), redacted["in_reply_to_user_id_str"])
+		assert.Equal(t, pseudonym("screen_name", "twitter"), redacted["in_reply_to_screen_name"])
+		assert.NotContains(t, redacted, "in_reply_to_user_id")
+
+		mention := redacted["entities"].(map[string]interface{})["user_mentions"].([]interface{})[0].(map[string]interface{})
+		assert.Equal(t, pseudonym("id", "783214"), mention["id_str"])
+		assert.Equal(t, pseudonym("screen_name", "twitter"), mention["screen_name"])
+		assert.NotContains(t, mention, "name")
+
+		for _, field := range []string{"coordinates", "geo", "place"} {
+			assert.NotContains(t, redacted, field)
+		}
+
+		// Links to tweets name their author
+		jack := pseudonym("screen_name", "jack")
+		url := redacted["entities"].(map[string]interface{})["urls"].([]interface{})[0].(map[string]interface{})
+		assert.Equal(t, "https://twitter.com/"+jack+"/status/20", url["expanded_url"])
+		assert.Equal(t, "twitter.com/"+jack+"/status/20", url["display_url"])
+		media := redacted["extended_entities"].(map[string]interface{})["media"].([]interface{})[0].(map[string]interface{})
+		assert.Equal(t, "https://twitter.com/"+pseudonym("screen_name", "twitter")+"/status/1/photo/1", media["expanded_url"])
+		assert.Equal(t, "pic.twitter.com/m", media["display_url"])
+		// Whichever fields make it through decoding, no link names an author
+		encoded, err := json.Marshal(redacted)
+		assert.NoError(t, err)
+		assert.NotContains(t, strings.ToLower(string(encoded)), "/jack/")
+		assert.NotContains(t, strings.ToLower(string(encoded)), "/twitter/")
+
+		retweeted := redacted["retweeted_status"].(map[string]interface{})
+		assert.Equal(t, pseudonym("id", "783214"), retweeted["user"].(map[string]interface{})["id_str"])
+		assert.NotContains(t, retweeted["user"], "name")
+		// Email addresses aren't mentions
+		assert.Equal(t, ("hi@example.com, @" + jack)[:40], retweeted["text"])
+
+		// Tweet IDs are kept
+		assert.Equal(t, "1", redacted["id_str"])
+	})
+
+	t.Run("v2 payload", func(t *testing.T) {
+		payload := `{"data":{"id":"1","text":"@TwitterDev hello","created_at":"2021-10-06T23:04:56.000Z","author_id":"2244994945",` +
+			`"geo":{"place_id":"01a9a39529b27f36"},"entities":{"mentions":[{"start":0,"end":11,"username":"TwitterDev","id":"2244994945"}],` +
+			`"urls":[{"url":"https://t.co/a","expanded_url":"https://twitter.com/TwitterDev/status/5","display_url":"twitter.com/TwitterDev/status/5"}]}},` +
+			`"includes":{"users":[{"id":"2244994945","username":"TwitterDev","name":"Twitter Dev","location":"127.0.0.1","description":"The voice of the developer platform","public_metrics":{"followers_count":5}}],` +
+			`"places":[{"id":"01a9a39529b27f36","full_name":"Manhattan, NY"}]},"matching_rules":[{"id":"10","tag":"spice/track:hello"}]}`
+
+		done := make(chan bool)
+		mux := http.NewServeMux()
+		mux.HandleFunc("/2/tweets/search/stream/rules", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet {
+				_, _ = w.Write([]byte(`{"data":[{"id":"10","value":"hello","tag":"spice/track:hello"}]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"meta":{}}`))
+		})
+		mux.HandleFunc("/2/tweets/search/stream", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(payload + "\r\n"))
+			w.(http.Flusher).Flush()
+			<-done
+		})
+		server := httptest.NewServer(mux)
+		t.Cleanup(func() {
+			close(done)
+			server.Close()
+		})
+
+		c := spice_twitter.NewTwitterConnector()
+		received := readTweet(t, c)
+
+		params := map[string]string{
+			"api_version":  "2",
+			"api_url":      server.URL,
+			"bearer_token": "test_token",
+			"track":        "hello",
+			"redact_users": "true",
+			"redact_salt":  salt,
+			"redact_text":  "strip",
+			"redact_geo":   "true",
+		}
+
+		err := c.Init(epoch, period, interval, params)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		var redacted map[string]interface{}
+		select {
+		case redacted = <-received:
+		case <-time.After(5 * time.Second):
+			t.Fatal("no tweet delivered")
+		}
+
+		data := redacted["data"].(map[string]interface{})
+		assert.Equal(t, "1", data["id"])
+		assert.Equal(t, "", data["text"])
+		assert.Equal(t, pseudonym("id", "2244994945"), data["author_id"])
+		assert.NotContains(t, data, "geo")
+
+		mention := data["entities"].(map[string]interface{})["mentions"].([]interface{})[0].(map[string]interface{})
+		assert.Equal(t, pseudonym("id", "2244994945"), mention["id"])
+		assert.Equal(t, pseudonym("screen_name", "twitterdev"), mention["username"])
+
+		url := data["entities"].(map[string]interface{})["urls"].([]interface{})[0].(map[string]interface{})
+		assert.Equal(t, "https://twitter.com/"+pseudonym("screen_name", "twitterdev")+"/status/5", url["expanded_url"])
+		assert.Equal(t, "twitter.com/"+pseudonym("screen_name", "twitterdev")+"/status/5", url["display_url"])
+
+		includes := redacted["includes"].(map[string]interface{})
+		assert.NotContains(t, includes, "places")
+		assert.Equal(t, []interface{}{
+			map[string]interface{}{
+				"id":             pseudonym("id", "2244994945"),
+				"username":       pseudonym("screen_name", "twitterdev"),
+				"public_metrics": map[string]interface{}{"followers_count": float64(5)},
+			},
+		}, includes["users"])
+
+		assert.Equal(t, []interface{}{map[string]interface{}{"id": "10", "tag": "track:hello"}}, redacted["matching_rules"])
+	})
+
+	t.Run("Init() with invalid redaction params", func(t *testing.T) {
+		invalidParams := []map[string]string{
+			{"redact_users": "true"},
+			{"redact_users": "true", "redact_salt": "too short"},
+			{"redact_users": "yes please", "redact_salt": salt},
+			{"redact_text": "hide"},
+			{"redact_text": "truncate", "redact_text_length": "0"},
+			{"redact_text": "truncate", "redact_text_length": "short"},
+			{"redact_geo": "maybe"},
+		}
+		for _, invalid := range invalidParams {
+			params := getAuthParams()
+			params["track"] = "bitcoin"
+			for key, value := range invalid {
+				params[key] = value
+			}
+			err := spice_twitter.NewTwitterConnector().Init(epoch, period, interval, params)
+			assert.Error(t, err, invalid)
+		}
+	})
+}
+
+// Serves the stream from handler, holding the connection open until the test ends
+func newMockStream(t *testing.T, handler http.HandlerFunc) *http.Client {
+	done := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r)
+		w.(http.Flusher).Flush()
+		<-done
+	}))
+	t.Cleanup(func() {
+		close(done)
+		server.Close()
+	})
+
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &http.Client{Transport: &redirectTransport{target: serverURL}}
+}
+
+// Sends requests for any host to target
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (r *redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = r.target.Scheme
+	req.URL.Host = r.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
 func getAuthParams() map[string]string {
 	return map[string]string{
 		"consumer_key":    "change_me",
@@ -0,0 +1,431 @@
+package twitter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/logrusorgru/aurora"
+)
+
+const (
+	// Selects the v2 filtered stream with an app bearer token
+	apiVersion2 string = "2"
+
+	defaultAPIURL string = "https://api.twitter.com"
+
+	defaultExpansions  string = "author_id"
+	defaultTweetFields string = "created_at,lang,public_metrics,author_id,entities"
+	defaultUserFields  string = "username,name,public_metrics,verified"
+
+	// Prefixes the tags of this connector's stream rules, set 'rule_namespace' to run several connectors on one bearer token
+	defaultRuleNamespace string = "spice"
+)
+
+type streamRule struct {
+	ID    string `json:"id,omitempty"`
+	Value string `json:"value"`
+	Tag   string `json:"tag,omitempty"`
+}
+
+func (r *streamRule) key() string {
+	return r.Value + "\x00" + r.Tag
+}
+
+// A filtered stream message. The raw line is delivered, only the matching rules are read here.
+type streamPayload struct {
+	Data          json.RawMessage `json:"data"`
+	MatchingRules []*streamRule   `json:"matching_rules"`
+	Errors        []*apiError     `json:"errors"`
+}
+
+type apiError struct {
+	Title   string `json:"title"`
+	Detail  string `json:"detail"`
+	Message string `json:"message"`
+}
+
+func (e *apiError) String() string {
+	for _, message := range []string{e.Detail, e.Message, e.Title} {
+		if message != "" {
+			return message
+		}
+	}
+	return "unknown error"
+}
+
+// Client for the v2 API, authenticated with an app bearer token
+type v2Client struct {
+	apiURL      string
+	bearerToken string
+	httpClient  *http.Client
+	// Rules belong to the app, so only rules tagged with this namespace belong to the connector
+	namespace string
+}
+
+// Builds the stream rules from the 'rules' param, a JSON array of {"value", "tag"},
+// and from the 'track' and 'follow' params
+func parseStreamRules(params map[string]string) ([]*streamRule, error) {
+	for _, param := range []string{"locations", "language", "filter_level"} {
+		if _, ok := params[param]; ok {
+			return nil, fmt.Errorf("'%s' is not supported for api_version 2, use rule operators in 'rules' instead", param)
+		}
+	}
+
+	var rules []*streamRule
+	if paramRules, ok := params["rules"]; ok {
+		err := json.Unmarshal([]byte(paramRules), &rules)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rules '%s': %s", paramRules, err)
+		}
+		for _, rule := range rules {
+			rule.ID = ""
+			rule.Value = strings.TrimSpace(rule.Value)
+			if rule.Value == "" {
+				return nil, fmt.Errorf("invalid rules '%s': every rule requires a value", paramRules)
+			}
+			if rule.Tag == "" {
+				rule.Tag = rule.Value
+			}
+		}
+	}
+
+	for _, term := range append(parseListParam(params["filter"]), parseListParam(params["track"])...) {
+		rules = append(rules, &streamRule{Value: term, Tag: trackRule + ":" + term})
+	}
+
+	for _, userID := range parseListParam(params["follow"]) {
+		rules = append(rules, &streamRule{Value: "from:" + userID, Tag: followRule + ":" + userID})
+	}
+
+	if len(rules) == 0 {
+		return nil, errors.New("filter is required: at least one of 'rules', 'track' or 'follow' must be set")
+	}
+
+	return rules, nil
+}
+
+func (c *v2Client) newRequest(ctx context.Context, method string, path string, query url.Values, body interface{}) (*http.Request, error) {
+	endpoint, err := url.Parse(c.apiURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid api_url '%s': %w", c.apiURL, err)
+	}
+	endpoint.Path = strings.TrimSuffix(endpoint.Path, "/") + path
+	endpoint.RawQuery = query.Encode()
+
+	var bodyReader io.Reader
+	if body != nil {
+		bodyData, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		bodyReader = bytes.NewReader(bodyData)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint.String(), bodyReader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.bearerToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return req, nil
+}
+
+// Sends a request and decodes the JSON response into out
+func (c *v2Client) do(ctx context.Context, method string, path string, body interface{}, out interface{}) error {
+	req, err := c.newRequest(ctx, method, path, nil, body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respData, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return responseError(method, path, resp, respData)
+	}
+
+	return json.Unmarshal(respData, out)
+}
+
+func responseError(method string, path string, resp *http.Response, respData []byte) error {
+	var errorResponse struct {
+		apiError
+		Errors []*apiError `json:"errors"`
+	}
+	if json.Unmarshal(respData, &errorResponse) == nil {
+		if len(errorResponse.Errors) > 0 {
+			return fmt.Errorf("twitter %s %s failed: %s: %s", method, path, resp.Status, errorResponse.Errors[0])
+		}
+		if errorResponse.Title != "" || errorResponse.Detail != "" {
+			return fmt.Errorf("twitter %s %s failed: %s: %s", method, path, resp.Status, &errorResponse.apiError)
+		}
+	}
+	return fmt.Errorf("twitter %s %s failed: %s", method, path, resp.Status)
+}
+
+func (c *v2Client) listRules(ctx context.Context) ([]*streamRule, error) {
+	var response struct {
+		Data []*streamRule `json:"data"`
+	}
+	err := c.do(ctx, http.MethodGet, "/2/tweets/search/stream/rules", nil, &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Data, nil
+}
+
+func (c *v2Client) updateRules(ctx context.Context, update interface{}) error {
+	var response struct {
+		Errors []*apiError `json:"errors"`
+	}
+	err := c.do(ctx, http.MethodPost, "/2/tweets/search/stream/rules", update, &response)
+	if err != nil {
+		return err
+	}
+	if len(response.Errors) > 0 {
+		return fmt.Errorf("twitter rejected stream rules: %s", response.Errors[0])
+	}
+	return nil
+}
+
+// Makes the stream rules in the connector's namespace match rules, deleting rules no longer configured
+// and adding new ones. Rules belong to the app, so every stream using the same bearer token shares them
+// and rules in other namespaces are left alone.
+func (c *v2Client) syncRules(ctx context.Context, rules []*streamRule) error {
+	existing, err := c.listRules(ctx)
+	if err != nil {
+		return err
+	}
+
+	namespaced := make([]*streamRule, len(rules))
+	desired := make(map[string]bool, len(rules))
+	for i, rule := range rules {
+		namespaced[i] = &streamRule{Value: rule.Value, Tag: c.namespacedTag(rule.Tag)}
+		desired[namespaced[i].key()] = true
+	}
+
+	current := make(map[string]bool, len(existing))
+	var deleteIDs []string
+	for _, rule := range existing {
+		if _, ok := c.ownTag(rule.Tag); !ok {
+			continue
+		}
+		if desired[rule.key()] && !current[rule.key()] {
+			current[rule.key()] = true
+			continue
+		}
+		deleteIDs = append(deleteIDs, rule.ID)
+	}
+
+	var add []*streamRule
+	for _, rule := range namespaced {
+		if !current[rule.key()] {
+			current[rule.key()] = true
+			add = append(add, rule)
+		}
+	}
+
+	if len(deleteIDs) > 0 {
+		sort.Strings(deleteIDs)
+		err = c.updateRules(ctx, map[string]interface{}{"delete": map[string][]string{"ids": deleteIDs}})
+		if err != nil {
+			return err
+		}
+		log.Printf("deleted %d twitter stream rules", len(deleteIDs))
+	}
+
+	if len(add) > 0 {
+		err = c.updateRules(ctx, map[string]interface{}{"add": add})
+		if err != nil {
+			return err
+		}
+		log.Printf("added %d twitter stream rules", len(add))
+	}
+
+	return nil
+}
+
+func (c *v2Client) namespacedTag(tag string) string {
+	return c.namespace + "/" + tag
+}
+
+// Returns the tag without the namespace, and whether it is in the connector's namespace
+func (c *v2Client) ownTag(tag string) (string, bool) {
+	prefix := c.namespace + "/"
+	if !strings.HasPrefix(tag, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(tag, prefix), true
+}
+
+// Returns an opener for the filtered stream, requesting the expansions and fields from the params
+func (c *v2Client) streamOpener(params map[string]string) streamOpener {
+	query := url.Values{}
+	query.Set("expansions", paramOrDefault(params, "expansions", defaultExpansions))
+	query.Set("tweet.fields", paramOrDefault(params, "tweet_fields", defaultTweetFields))
+	query.Set("user.fields", paramOrDefault(params, "user_fields", defaultUserFields))
+
+	return func(ctx context.Context) (*http.Response, error) {
+		req, err := c.newRequest(ctx, http.MethodGet, "/2/tweets/search/stream", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		return c.httpClient.Do(req)
+	}
+}
+
+func (c *TwitterConnector) initV2(params map[string]string) error {
+	bearerToken := params["bearer_token"]
+	if bearerToken == "" {
+		return errors.New("bearer_token is required")
+	}
+
+	rules, err := parseStreamRules(params)
+	if err != nil {
+		return err
+	}
+
+	apiURL, err := parseAPIURL(params)
+	if err != nil {
+		return err
+	}
+
+	namespace := paramOrDefault(params, "rule_namespace", defaultRuleNamespace)
+	if strings.Contains(namespace, "/") {
+		return fmt.Errorf("invalid rule_namespace '%s': must not contain '/'", namespace)
+	}
+
+	c.SetHTTPClient(&http.Client{})
+
+	c.v2 = &v2Client{
+		apiURL:      apiURL,
+		bearerToken: bearerToken,
+		httpClient:  c.httpClient,
+		namespace:   namespace,
+	}
+
+	ctx := context.Background()
+
+	err = c.v2.syncRules(ctx, rules)
+	if err != nil {
+		return err
+	}
+
+	if c.backfill != nil {
+		c.backfill.begin()
+	}
+
+	err = c.startStream(c.v2.streamOpener(params), c.handleV2Payload)
+	if err != nil {
+		return fmt.Errorf("failed to open twitter stream: %w", err)
+	}
+
+	var ruleValues []string
+	for _, rule := range rules {
+		ruleValues = append(ruleValues, rule.Value)
+	}
+	log.Println(aurora.Green(fmt.Sprintf("started reading twitter v2 stream with rules: %s", strings.Join(ruleValues, "; "))))
+
+	if c.backfill != nil {
+		go c.runBackfill(c.searchV2(rules, params))
+	}
+
+	return nil
+}
+
+// Delivers each tweet as its stream payload, with only the connector's matching rules.
+// Tweets that only matched rules of other connectors on the same app are dropped.
+func (c *TwitterConnector) handleV2Payload(line []byte) error {
+	var payload streamPayload
+	err := json.Unmarshal(line, &payload)
+	if err != nil {
+		log.Printf("invalid twitter stream payload: %s", err.Error())
+		return nil
+	}
+
+	if len(payload.Data) == 0 {
+		// Errors without data, such as operational-disconnect, precede the stream closing
+		if len(payload.Errors) > 0 {
+			c.recordDisconnect(payload.Errors[0].String())
+		}
+		return nil
+	}
+
+	type matchingRule struct {
+		ID  string `json:"id"`
+		Tag string `json:"tag"`
+	}
+
+	var tags []string
+	var ownRules []*matchingRule
+	for _, rule := range payload.MatchingRules {
+		if tag, ok := c.v2.ownTag(rule.Tag); ok {
+			tags = append(tags, tag)
+			ownRules = append(ownRules, &matchingRule{ID: rule.ID, Tag: tag})
+		}
+	}
+	if len(ownRules) == 0 {
+		return nil
+	}
+
+	// Rewrite the matching rules, keeping the rest of the payload as is
+	var message map[string]json.RawMessage
+	err = json.Unmarshal(line, &message)
+	if err != nil {
+		log.Printf("invalid twitter stream payload: %s", err.Error())
+		return nil
+	}
+	message["matching_rules"], err = json.Marshal(ownRules)
+	if err != nil {
+		return err
+	}
+	line, err = json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	var tweet struct {
+		ID string `json:"id"`
+	}
+	_ = json.Unmarshal(payload.Data, &tweet)
+
+	c.sendTweet("tweet_v2", tweet.ID, line, tags)
+
+	return nil
+}
+
+// Returns the base URL of the REST API from 'api_url', for tests and proxies
+func parseAPIURL(params map[string]string) (string, error) {
+	apiURL := paramOrDefault(params, "api_url", defaultAPIURL)
+	if _, err := url.ParseRequestURI(apiURL); err != nil {
+		return "", fmt.Errorf("invalid api_url '%s': %s", apiURL, err)
+	}
+	return apiURL, nil
+}
+
+func paramOrDefault(params map[string]string, param string, defaultValue string) string {
+	if value, ok := params[param]; ok && value != "" {
+		return value
+	}
+	return defaultValue
+}
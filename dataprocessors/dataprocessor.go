@@ -6,6 +6,7 @@ import (
 	"github.com/spiceai/data-components-contrib/dataprocessors/csv"
 	"github.com/spiceai/data-components-contrib/dataprocessors/flux"
 	"github.com/spiceai/data-components-contrib/dataprocessors/json"
+	"github.com/spiceai/data-components-contrib/dataprocessors/tweetvolume"
 	"github.com/spiceai/spiceai/pkg/observations"
 	"github.com/spiceai/spiceai/pkg/state"
 )
@@ -25,6 +26,8 @@ func NewDataProcessor(name string) (DataProcessor, error) {
 		return flux.NewFluxCsvProcessor(), nil
 	case json.JsonProcessorName:
 		return json.NewJsonProcessor(), nil
+	case tweetvolume.TweetVolumeProcessorName:
+		return tweetvolume.NewTweetVolumeProcessor(), nil
 	}
 
 	return nil, fmt.Errorf("unknown processor '%s'", name)
@@ -0,0 +1,31 @@
+// Package tweettext holds the text handling shared by the processors that read tweets.
+package tweettext
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Tokenize splits text into lower case words, skipping links and mentions and reading hashtags as words
+func Tokenize(text string) []string {
+	text = strings.ToLower(strings.ReplaceAll(text, "’", "'"))
+
+	var words []string
+	for _, field := range strings.Fields(text) {
+		if strings.HasPrefix(field, "@") || strings.HasPrefix(field, "http://") || strings.HasPrefix(field, "https://") {
+			continue
+		}
+
+		splitWords := strings.FieldsFunc(field, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\'' && r != '-'
+		})
+		for _, word := range splitWords {
+			word = strings.Trim(word, "'-")
+			if word != "" {
+				words = append(words, word)
+			}
+		}
+	}
+
+	return words
+}
@@ -0,0 +1,13 @@
+package tweettext_test
+
+import (
+	"testing"
+
+	"github.com/spiceai/data-components-contrib/dataprocessors/internal/tweettext"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenize(t *testing.T) {
+	assert.Equal(t, []string{"eth", "isn't", "going", "to-the-moon", "whether", "or", "not", "says", "so"}, tweettext.Tokenize("#ETH isn’t going TO-THE-MOON, whether or not @jack says so https://t.co/abc"))
+	assert.Empty(t, tweettext.Tokenize("@jack https://t.co/abc --"))
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/spiceai/data-components-contrib/dataprocessors/json/observation"
 	"github.com/spiceai/data-components-contrib/dataprocessors/json/tweet"
+	"github.com/spiceai/data-components-contrib/dataprocessors/json/tweetv2"
 	"github.com/spiceai/spiceai/pkg/observations"
 	"github.com/spiceai/spiceai/pkg/state"
 	"github.com/spiceai/spiceai/pkg/util"
@@ -31,6 +32,12 @@ type JsonFormat interface {
 	GetState(data []byte, validFields []string) ([]*state.State, error)
 }
 
+// ConfigurableJsonFormat is implemented by formats that take options from the processor params
+type ConfigurableJsonFormat interface {
+	JsonFormat
+	Init(params map[string]string) error
+}
+
 type ValidationError struct {
 	message         string
 	validationError string
@@ -55,6 +62,8 @@ func (p *JsonProcessor) Init(params map[string]string) error {
 	switch format {
 	case "tweet":
 		p.format = &tweet.TweetJsonFormat{}
+	case "tweet_v2":
+		p.format = &tweetv2.TweetV2JsonFormat{}
 	case "default":
 		p.format = &observation.ObservationJsonFormat{}
 	}
@@ -63,6 +72,13 @@ func (p *JsonProcessor) Init(params map[string]string) error {
 		return fmt.Errorf("unable to find json format '%s'", format)
 	}
 
+	if configurableFormat, ok := p.format.(ConfigurableJsonFormat); ok {
+		err := configurableFormat.Init(params)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -40,9 +40,26 @@ func TestJson(t *testing.T) {
 		t.Fatal(err.Error())
 	}
 
+	state_data, err := os.ReadFile("../../test/assets/data/json/observation_state.json")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tweet_data, err := os.ReadFile("../../test/assets/data/json/tweet_valid.json")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tweet_v2_data, err := os.ReadFile("../../test/assets/data/json/tweet_v2_valid.json")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
 	t.Run("Init()", testInitFunc())
 	t.Run("Init() with invalid params", testInvalidInitFunc())
 	t.Run("GetObservations()", testGetObservationsFunc(data))
+	t.Run("GetObservations() -- with the tweet format and selected fields", testGetObservationsTweetFunc(tweet_data))
+	t.Run("GetObservations() -- with the tweet_v2 format", testGetObservationsTweetV2Func(tweet_v2_data))
 	t.Run("GetObservations() -- with a string value for some data points", testGetObservationsFunc(string_valid_value))
 	t.Run("GetObservations() -- with an invalid string value for some data points", testGetObservationsInvalidStringFunc(string_invalid_value))
 	t.Run("GetObservations() called before Init()", testGetObservationsNoInitFunc())
@@ -52,6 +69,9 @@ func TestJson(t *testing.T) {
 	t.Run("OnData() called with invalid schema", testOnDataInvalidSchema(invalid_data, "0: (root): Invalid type. Expected: array, given: object"))
 	t.Run("OnData() called with invalid time", testOnDataInvalidSchema(invalid_time, "0: 0.time: Must validate at least one schema (anyOf)"))
 	t.Run("GetState() called before Init()", testGetStateNoInitFunc())
+	t.Run("GetState()", testGetStateFunc(state_data))
+	t.Run("GetState() with unknown fields", testGetStateUnknownFieldsFunc(state_data))
+	t.Run("GetState() with fields that are not fully-qualified", testGetStateUnqualifiedFunc(data))
 }
 
 // Tests "Init()"
@@ -117,6 +137,44 @@ func testGetObservationsFunc(data []byte) func(*testing.T) {
 	}
 }
 
+// Tests "GetObservations()" with the Twitter stream format, choosing fields with the params
+func testGetObservationsTweetFunc(data []byte) func(*testing.T) {
+	return func(t *testing.T) {
+		dp := NewJsonProcessor()
+		err := dp.Init(map[string]string{"format": "tweet", "fields": "nonexist"})
+		assert.Error(t, err)
+
+		err = dp.Init(map[string]string{"format": "tweet", "fields": "favorite_count,has_url"})
+		assert.NoError(t, err)
+
+		_, err = dp.OnData(data)
+		assert.NoError(t, err)
+
+		actualObservations, err := dp.GetObservations()
+		if assert.NoError(t, err) && assert.Len(t, actualObservations, 1) {
+			assert.Equal(t, map[string]float64{"favorite_count": 123, "has_url": 0}, actualObservations[0].Data)
+		}
+	}
+}
+
+// Tests "GetObservations()" with the Twitter API v2 stream format
+func testGetObservationsTweetV2Func(data []byte) func(*testing.T) {
+	return func(t *testing.T) {
+		dp := NewJsonProcessor()
+		err := dp.Init(map[string]string{"format": "tweet_v2"})
+		assert.NoError(t, err)
+
+		_, err = dp.OnData(data)
+		assert.NoError(t, err)
+
+		actualObservations, err := dp.GetObservations()
+		if assert.NoError(t, err) && assert.Len(t, actualObservations, 2) {
+			assert.Equal(t, int64(1633561496), actualObservations[0].Time)
+			assert.Equal(t, []string{"crypto_news", "en", "track:bitcoin"}, actualObservations[0].Tags)
+		}
+	}
+}
+
 // Tests "GetObservations()" when given an invalid string data point
 func testGetObservationsInvalidStringFunc(data []byte) func(*testing.T) {
 	return func(t *testing.T) {
@@ -271,3 +329,76 @@ func testGetStateNoInitFunc() func(*testing.T) {
 		assert.Nil(t, state)
 	}
 }
+
+// Tests "GetState()"
+func testGetStateFunc(data []byte) func(*testing.T) {
+	return func(t *testing.T) {
+		dp := NewJsonProcessor()
+		err := dp.Init(nil)
+		assert.NoError(t, err)
+
+		_, err = dp.OnData(data)
+		assert.NoError(t, err)
+
+		actualState, err := dp.GetState(nil)
+		if !assert.NoError(t, err) || !assert.Len(t, actualState, 2) {
+			return
+		}
+
+		assert.Equal(t, "coinbase.btcusd", actualState[0].Path())
+		assert.Equal(t, []string{"price", "volume"}, actualState[0].FieldNames())
+		assert.Equal(t, []string{"tagA", "tagB"}, actualState[0].Tags())
+		assert.Equal(t, []observations.Observation{
+			{
+				Time: 1605312000,
+				Data: map[string]float64{"price": 15678.2, "volume": 120},
+				Tags: []string{"tagA"},
+			},
+			{
+				Time: 1605315600,
+				Data: map[string]float64{"price": 15702.5, "volume": 98},
+				Tags: []string{"tagB"},
+			},
+		}, actualState[0].Observations())
+
+		assert.Equal(t, "local.portfolio", actualState[1].Path())
+		assert.Equal(t, []string{"usd_balance"}, actualState[1].FieldNames())
+		assert.Len(t, actualState[1].Observations(), 1)
+
+		state, err := dp.GetState(nil)
+		assert.NoError(t, err)
+		assert.Nil(t, state)
+	}
+}
+
+// Tests "GetState()" with a field missing from validFields
+func testGetStateUnknownFieldsFunc(data []byte) func(*testing.T) {
+	return func(t *testing.T) {
+		dp := NewJsonProcessor()
+		err := dp.Init(nil)
+		assert.NoError(t, err)
+
+		_, err = dp.OnData(data)
+		assert.NoError(t, err)
+
+		_, err = dp.GetState([]string{"coinbase.btcusd.price", "coinbase.btcusd.volume"})
+		if assert.Error(t, err) {
+			assert.Equal(t, "unknown field 'local.portfolio.usd_balance'", err.Error())
+		}
+	}
+}
+
+// Tests "GetState()" with data keys that have no path
+func testGetStateUnqualifiedFunc(data []byte) func(*testing.T) {
+	return func(t *testing.T) {
+		dp := NewJsonProcessor()
+		err := dp.Init(nil)
+		assert.NoError(t, err)
+
+		_, err = dp.OnData(data)
+		assert.NoError(t, err)
+
+		_, err = dp.GetState(nil)
+		assert.Error(t, err)
+	}
+}
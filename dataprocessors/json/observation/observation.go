@@ -3,7 +3,9 @@ package observation
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/spiceai/spiceai/pkg/api/observation"
@@ -71,7 +73,89 @@ func (s *ObservationJsonFormat) GetObservations(data []byte) ([]observations.Obs
 	return newObservations, nil
 }
 
+// Processes into State by field path
+// Data keys are expected to be fully-qualified field names
 func (s *ObservationJsonFormat) GetState(data []byte, validFields []string) ([]*state.State, error) {
-	// TODO
-	return nil, nil
+	newObservations, err := s.GetObservations(data)
+	if err != nil {
+		return nil, err
+	}
+
+	pathToObservations := make(map[string][]observations.Observation)
+	pathToFieldNames := make(map[string]map[string]bool)
+	// Map from path -> set of detected tags on that path
+	pathToTags := make(map[string]map[string]bool)
+
+	for _, observation := range newObservations {
+		pathData := make(map[string]map[string]float64)
+
+		for field, value := range observation.Data {
+			if validFields != nil && !containsString(validFields, field) {
+				return nil, fmt.Errorf("unknown field '%s'", field)
+			}
+
+			dotIndex := strings.LastIndex(field, ".")
+			if dotIndex == -1 {
+				return nil, fmt.Errorf("field '%s' expected to be fully-qualified", field)
+			}
+			path := field[:dotIndex]
+			fieldName := field[dotIndex+1:]
+
+			if pathData[path] == nil {
+				pathData[path] = make(map[string]float64)
+			}
+			pathData[path][fieldName] = value
+
+			if pathToFieldNames[path] == nil {
+				pathToFieldNames[path] = make(map[string]bool)
+			}
+			pathToFieldNames[path][fieldName] = true
+		}
+
+		for path, data := range pathData {
+			pathToObservations[path] = append(pathToObservations[path], observations.Observation{
+				Time: observation.Time,
+				Data: data,
+				Tags: observation.Tags,
+			})
+
+			if pathToTags[path] == nil {
+				pathToTags[path] = make(map[string]bool)
+			}
+			for _, tag := range observation.Tags {
+				pathToTags[path][tag] = true
+			}
+		}
+	}
+
+	paths := make([]string, 0, len(pathToObservations))
+	for path := range pathToObservations {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	result := make([]*state.State, len(paths))
+	for i, path := range paths {
+		result[i] = state.NewState(path, sortedKeys(pathToFieldNames[path]), sortedKeys(pathToTags[path]), pathToObservations[path])
+	}
+
+	return result, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
 }
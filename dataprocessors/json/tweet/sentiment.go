@@ -0,0 +1,174 @@
+package tweet
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spiceai/data-components-contrib/dataprocessors/internal/tweettext"
+)
+
+var (
+	//go:embed sentiment_lexicon.tsv
+	bundledLexiconData []byte
+
+	bundledLexicon = mustParseLexicon(bundledLexiconData)
+
+	// Words that flip the score of a term up to two words after them, e.g. "not good" or "not very good"
+	negations = map[string]bool{
+		"not":     true,
+		"no":      true,
+		"never":   true,
+		"nothing": true,
+		"don't":   true,
+		"dont":    true,
+		"doesn't": true,
+		"didn't":  true,
+		"isn't":   true,
+		"isnt":    true,
+		"wasn't":  true,
+		"aren't":  true,
+		"can't":   true,
+		"cant":    true,
+		"won't":   true,
+	}
+)
+
+const negationWindow = 2
+
+// Scores of terms, single words or phrases of up to maxWords words
+type sentimentLexicon struct {
+	terms    map[string]float64
+	maxWords int
+}
+
+type sentiment struct {
+	score         float64
+	positiveTerms int
+	negativeTerms int
+}
+
+func mustParseLexicon(data []byte) *sentimentLexicon {
+	lexicon := &sentimentLexicon{terms: make(map[string]float64)}
+	err := lexicon.read(bytes.NewReader(data))
+	if err != nil {
+		panic(fmt.Sprintf("invalid bundled sentiment lexicon: %s", err))
+	}
+	return lexicon
+}
+
+// Returns the bundled lexicon extended, and overridden, by the terms in the lexicon file at path
+func loadLexicon(path string) (*sentimentLexicon, error) {
+	lexicon := &sentimentLexicon{
+		terms:    make(map[string]float64, len(bundledLexicon.terms)),
+		maxWords: bundledLexicon.maxWords,
+	}
+	for term, score := range bundledLexicon.terms {
+		lexicon.terms[term] = score
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	err = lexicon.read(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return lexicon, nil
+}
+
+// Reads lines of a term, a tab and its score, skipping blank lines and # comments
+func (l *sentimentLexicon) read(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		separator := strings.LastIndex(line, "\t")
+		if separator < 0 {
+			return fmt.Errorf("line %d: expected a term and score separated by a tab", lineNumber)
+		}
+
+		words := tweettext.Tokenize(line[:separator])
+		if len(words) == 0 {
+			return fmt.Errorf("line %d: term is empty", lineNumber)
+		}
+
+		score, err := strconv.ParseFloat(strings.TrimSpace(line[separator+1:]), 64)
+		if err != nil {
+			return fmt.Errorf("line %d: invalid score: %s", lineNumber, err)
+		}
+
+		l.terms[strings.Join(words, " ")] = score
+		if len(words) > l.maxWords {
+			l.maxWords = len(words)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Sums the scores of the lexicon terms in text, matching the longest phrase first
+func (l *sentimentLexicon) score(text string) sentiment {
+	var result sentiment
+
+	words := tweettext.Tokenize(text)
+	for i := 0; i < len(words); {
+		matched := 0
+		var score float64
+		for n := minInt(l.maxWords, len(words)-i); n > 0; n-- {
+			if s, ok := l.terms[strings.Join(words[i:i+n], " ")]; ok {
+				matched, score = n, s
+				break
+			}
+		}
+
+		if matched == 0 {
+			i++
+			continue
+		}
+
+		if isNegated(words, i) {
+			score = -score
+		}
+
+		result.score += score
+		switch {
+		case score > 0:
+			result.positiveTerms++
+		case score < 0:
+			result.negativeTerms++
+		}
+
+		i += matched
+	}
+
+	return result
+}
+
+func isNegated(words []string, i int) bool {
+	for j := i - 1; j >= 0 && j >= i-negationWindow; j-- {
+		if negations[words[j]] {
+			return true
+		}
+	}
+	return false
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -4,18 +4,106 @@ import (
 	_ "embed"
 	"encoding/json"
 	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/dghubble/go-twitter/twitter"
+	"github.com/spiceai/spiceai/pkg/loggers"
 	"github.com/spiceai/spiceai/pkg/observations"
 	"github.com/spiceai/spiceai/pkg/state"
+	"go.uber.org/zap"
 )
 
 var (
 	//go:embed tweet_schema.json
 	jsonSchema []byte
+
+	zaplog *zap.Logger = loggers.ZapLogger()
+)
+
+const (
+	defaultStatePath string = "twitter.tweets"
+
+	langPlaceholder    string = "<lang>"
+	hashtagPlaceholder string = "<hashtag>"
+)
+
+var (
+	// Fields that can be selected with the 'fields' param, all by default
+	fieldNames = []string{
+		"favorite_count",
+		"retweet_count",
+		"reply_count",
+		"quote_count",
+		"author_followers_count",
+		"author_friends_count",
+		"author_verified",
+		"text_length",
+		"has_media",
+		"has_url",
+		"sentiment_score",
+		"positive_terms",
+		"negative_terms",
+	}
+
+	// Tags that can be selected with the 'tags' param
+	tagNames = []string{
+		"lang",
+		"hashtags",
+		"mentions",
+	}
+
+	// Hashtags and mentions have a tag per distinct value, so only the language is tagged unless 'tags' is set
+	defaultTags = map[string]bool{
+		"lang": true,
+	}
 )
 
 type TweetJsonFormat struct {
+	fields    map[string]bool
+	tags      map[string]bool
+	statePath string
+	lexicon   *sentimentLexicon
+}
+
+// Init selects the observation fields and tags from the comma-separated 'fields' and 'tags' params,
+// the path state is grouped by from 'state_path', and a lexicon file extending the bundled sentiment
+// lexicon from 'sentiment_lexicon'
+func (s *TweetJsonFormat) Init(params map[string]string) error {
+	fields, err := parseSelection(params, "fields", fieldNames)
+	if err != nil {
+		return err
+	}
+	s.fields = fields
+
+	tags, err := parseSelection(params, "tags", tagNames)
+	if err != nil {
+		return err
+	}
+	s.tags = tags
+
+	statePath, err := parseStatePath(params)
+	if err != nil {
+		return err
+	}
+	s.statePath = statePath
+
+	s.lexicon = nil
+	if lexiconPath, ok := params["sentiment_lexicon"]; ok {
+		if !filepath.IsAbs(lexiconPath) {
+			lexiconPath = filepath.Join(params["appDirectory"], lexiconPath)
+		}
+
+		lexicon, err := loadLexicon(lexiconPath)
+		if err != nil {
+			return fmt.Errorf("invalid sentiment_lexicon '%s': %s", lexiconPath, err)
+		}
+		s.lexicon = lexicon
+	}
+
+	return nil
 }
 
 func (s *TweetJsonFormat) GetSchema() []byte {
@@ -37,15 +125,10 @@ func (s *TweetJsonFormat) GetObservations(data []byte) ([]observations.Observati
 			return nil, fmt.Errorf("tweet time format is invalid: %s", tweet.CreatedAt)
 		}
 
-		data := make(map[string]float64)
-		data["favorite_count"] = float64(tweet.FavoriteCount)
-
-		tags := []string{tweet.Lang}
-
 		observation := observations.Observation{
 			Time: t.Unix(),
-			Data: data,
-			Tags: tags,
+			Data: s.getFields(&tweet),
+			Tags: s.getTags(&tweet),
 		}
 
 		newObservations = append(newObservations, observation)
@@ -54,7 +137,309 @@ func (s *TweetJsonFormat) GetObservations(data []byte) ([]observations.Observati
 	return newObservations, nil
 }
 
+// Processes into State by the path from 'state_path', "twitter.tweets" by default.
+// The path may group tweets by language with <lang>, or by hashtag with <hashtag>,
+// in which case a tweet is in the state of each of its hashtags and tweets without hashtags are skipped.
 func (s *TweetJsonFormat) GetState(data []byte, validFields []string) ([]*state.State, error) {
-	// TODO
-	return nil, nil
+	var tweets []twitter.Tweet
+
+	err := json.Unmarshal(data, &tweets)
+	if err != nil {
+		return nil, err
+	}
+
+	pathToObservations := make(map[string][]observations.Observation)
+	// Map from path -> set of detected tags on that path
+	pathToTags := make(map[string]map[string]bool)
+
+	for _, tweet := range tweets {
+		t, err := tweet.CreatedAtTime()
+		if err != nil {
+			return nil, fmt.Errorf("tweet time format is invalid: %s", tweet.CreatedAt)
+		}
+
+		observation := observations.Observation{
+			Time: t.Unix(),
+			Data: s.getFields(&tweet),
+			Tags: s.getTags(&tweet),
+		}
+
+		for _, path := range s.getStatePaths(&tweet) {
+			pathToObservations[path] = append(pathToObservations[path], observation)
+
+			if pathToTags[path] == nil {
+				pathToTags[path] = make(map[string]bool)
+			}
+			for _, tag := range observation.Tags {
+				pathToTags[path][tag] = true
+			}
+		}
+	}
+
+	var selectedFields []string
+	for _, field := range fieldNames {
+		if s.selected(s.fields, field) {
+			selectedFields = append(selectedFields, field)
+		}
+	}
+
+	paths := make([]string, 0, len(pathToObservations))
+	for path := range pathToObservations {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	// Grouped paths come from the tweets, so the pod may only declare some of them
+	grouped := s.statePath != strings.ReplaceAll(strings.ReplaceAll(s.statePath, langPlaceholder, ""), hashtagPlaceholder, "")
+
+	var result []*state.State
+	for _, path := range paths {
+		if validFields != nil {
+			if grouped && !hasPathPrefix(validFields, path) {
+				zaplog.Sugar().Debugf("skipping %d tweets for state path '%s', which has no fields in the pod", len(pathToObservations[path]), path)
+				continue
+			}
+			for _, fieldName := range selectedFields {
+				field := path + "." + fieldName
+				if !containsString(validFields, field) {
+					return nil, fmt.Errorf("unknown field '%s'", field)
+				}
+			}
+		}
+
+		tags := make([]string, 0, len(pathToTags[path]))
+		for tag := range pathToTags[path] {
+			tags = append(tags, tag)
+		}
+		sort.Strings(tags)
+
+		result = append(result, state.NewState(path, selectedFields, tags, pathToObservations[path]))
+	}
+
+	return result, nil
+}
+
+func (s *TweetJsonFormat) getFields(tweet *twitter.Tweet) map[string]float64 {
+	entities := tweetEntities(tweet)
+
+	var followersCount, friendsCount int
+	var verified bool
+	if tweet.User != nil {
+		followersCount = tweet.User.FollowersCount
+		friendsCount = tweet.User.FriendsCount
+		verified = tweet.User.Verified
+	}
+
+	hasURL := entities != nil && len(entities.Urls) > 0
+
+	values := map[string]float64{
+		"favorite_count":         float64(tweet.FavoriteCount),
+		"retweet_count":          float64(tweet.RetweetCount),
+		"reply_count":            float64(tweet.ReplyCount),
+		"quote_count":            float64(tweet.QuoteCount),
+		"author_followers_count": float64(followersCount),
+		"author_friends_count":   float64(friendsCount),
+		"author_verified":        boolToFloat(verified),
+		"text_length":            float64(utf8.RuneCountInString(tweetText(tweet))),
+		"has_media":              boolToFloat(hasMedia(tweet)),
+		"has_url":                boolToFloat(hasURL),
+	}
+
+	if s.selected(s.fields, "sentiment_score") || s.selected(s.fields, "positive_terms") || s.selected(s.fields, "negative_terms") {
+		lexicon := s.lexicon
+		if lexicon == nil {
+			lexicon = bundledLexicon
+		}
+		sentiment := lexicon.score(tweetText(tweet))
+		values["sentiment_score"] = sentiment.score
+		values["positive_terms"] = float64(sentiment.positiveTerms)
+		values["negative_terms"] = float64(sentiment.negativeTerms)
+	}
+
+	data := make(map[string]float64)
+	for field, value := range values {
+		if s.selected(s.fields, field) {
+			data[field] = value
+		}
+	}
+
+	return data
+}
+
+// Returns the language, then hashtags as "#tag" and mentions as "@screen_name", lower cased and in order of appearance
+func (s *TweetJsonFormat) getTags(tweet *twitter.Tweet) []string {
+	selection := s.tags
+	if selection == nil {
+		selection = defaultTags
+	}
+
+	tags := []string{}
+	if selection["lang"] && tweet.Lang != "" {
+		tags = append(tags, tweet.Lang)
+	}
+
+	entities := tweetEntities(tweet)
+	if entities == nil {
+		return tags
+	}
+
+	seen := make(map[string]bool)
+	addTag := func(tag string) {
+		tag = strings.ToLower(tag)
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+
+	if selection["hashtags"] {
+		for _, hashtag := range entities.Hashtags {
+			addTag("#" + hashtag.Text)
+		}
+	}
+
+	if selection["mentions"] {
+		for _, mention := range entities.UserMentions {
+			addTag("@" + mention.ScreenName)
+		}
+	}
+
+	return tags
+}
+
+// Returns the state paths of tweet, one per hashtag when grouping by hashtag
+func (s *TweetJsonFormat) getStatePaths(tweet *twitter.Tweet) []string {
+	statePath := s.statePath
+	if statePath == "" {
+		statePath = defaultStatePath
+	}
+
+	lang := tweet.Lang
+	if lang == "" {
+		// Twitter's code for an undetermined language
+		lang = "und"
+	}
+	statePath = strings.ReplaceAll(statePath, langPlaceholder, strings.ToLower(lang))
+
+	if !strings.Contains(statePath, hashtagPlaceholder) {
+		return []string{statePath}
+	}
+
+	entities := tweetEntities(tweet)
+	if entities == nil {
+		return nil
+	}
+
+	var paths []string
+	seen := make(map[string]bool)
+	for _, hashtag := range entities.Hashtags {
+		hashtagText := strings.ToLower(hashtag.Text)
+		if !seen[hashtagText] {
+			seen[hashtagText] = true
+			paths = append(paths, strings.ReplaceAll(statePath, hashtagPlaceholder, hashtagText))
+		}
+	}
+
+	return paths
+}
+
+// Everything is selected unless the param narrowed the selection
+func (s *TweetJsonFormat) selected(selection map[string]bool, name string) bool {
+	return selection == nil || selection[name]
+}
+
+func parseSelection(params map[string]string, param string, validNames []string) (map[string]bool, error) {
+	value, ok := params[param]
+	if !ok {
+		return nil, nil
+	}
+
+	valid := make(map[string]bool, len(validNames))
+	for _, name := range validNames {
+		valid[name] = true
+	}
+
+	selection := make(map[string]bool)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !valid[name] {
+			return nil, fmt.Errorf("invalid %s '%s': unknown %s '%s', must be one of %s", param, value, strings.TrimSuffix(param, "s"), name, strings.Join(validNames, ", "))
+		}
+		selection[name] = true
+	}
+
+	return selection, nil
+}
+
+func parseStatePath(params map[string]string) (string, error) {
+	statePath, ok := params["state_path"]
+	if !ok {
+		return defaultStatePath, nil
+	}
+
+	remaining := strings.ReplaceAll(strings.ReplaceAll(statePath, langPlaceholder, ""), hashtagPlaceholder, "")
+	if strings.ContainsAny(remaining, "<>") {
+		return "", fmt.Errorf("invalid state_path '%s': only %s and %s can be substituted", statePath, langPlaceholder, hashtagPlaceholder)
+	}
+	if statePath == "" || strings.HasPrefix(statePath, ".") || strings.HasSuffix(statePath, ".") || strings.Contains(statePath, "..") {
+		return "", fmt.Errorf("invalid state_path '%s': expected a dotted path such as 'twitter.%s'", statePath, langPlaceholder)
+	}
+
+	return statePath, nil
+}
+
+func hasPathPrefix(fields []string, path string) bool {
+	for _, field := range fields {
+		if strings.HasPrefix(field, path+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Extended tweets carry the full text and entities outside the truncated ones
+func tweetText(tweet *twitter.Tweet) string {
+	if tweet.ExtendedTweet != nil && tweet.ExtendedTweet.FullText != "" {
+		return tweet.ExtendedTweet.FullText
+	}
+	if tweet.FullText != "" {
+		return tweet.FullText
+	}
+	return tweet.Text
+}
+
+func tweetEntities(tweet *twitter.Tweet) *twitter.Entities {
+	if tweet.ExtendedTweet != nil && tweet.ExtendedTweet.Entities != nil {
+		return tweet.ExtendedTweet.Entities
+	}
+	return tweet.Entities
+}
+
+func hasMedia(tweet *twitter.Tweet) bool {
+	if entities := tweetEntities(tweet); entities != nil && len(entities.Media) > 0 {
+		return true
+	}
+	if tweet.ExtendedTweet != nil && tweet.ExtendedTweet.ExtendedEntities != nil && len(tweet.ExtendedTweet.ExtendedEntities.Media) > 0 {
+		return true
+	}
+	return tweet.ExtendedEntities != nil && len(tweet.ExtendedEntities.Media) > 0
+}
+
+func boolToFloat(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
 }
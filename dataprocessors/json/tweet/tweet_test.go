@@ -1,11 +1,15 @@
 package tweet_test
 
 import (
+	"encoding/json"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/bradleyjkemp/cupaloy"
+	"github.com/dghubble/go-twitter/twitter"
 	"github.com/spiceai/data-components-contrib/dataprocessors/json/tweet"
+	"github.com/spiceai/spiceai/pkg/state"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -33,3 +37,247 @@ func TestTweetJson(t *testing.T) {
 		}
 	}
 }
+
+func TestTweetJsonParams(t *testing.T) {
+	data, err := os.ReadFile("../../../test/assets/data/json/tweet_valid.json")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tweetJsonFormat := &tweet.TweetJsonFormat{}
+	err = tweetJsonFormat.Init(map[string]string{
+		"fields": "retweet_count, author_verified,text_length",
+		"tags":   "hashtags",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	observations, err := tweetJsonFormat.GetObservations(data)
+	if assert.NoError(t, err) && assert.Len(t, observations, 1) {
+		assert.Equal(t, map[string]float64{
+			"retweet_count":   0,
+			"author_verified": 0,
+			"text_length":     127,
+		}, observations[0].Data)
+		assert.Equal(t, []string{"#squidgame"}, observations[0].Tags)
+	}
+
+	err = tweetJsonFormat.Init(map[string]string{"tags": "lang,hashtags,mentions"})
+	if assert.NoError(t, err) {
+		observations, err := tweetJsonFormat.GetObservations(data)
+		if assert.NoError(t, err) && assert.Len(t, observations, 1) {
+			assert.Equal(t, []string{"en", "#squidgame", "@shanselman"}, observations[0].Tags)
+		}
+	}
+
+	// Only the language is tagged by default, and not when the tweet has none
+	err = tweetJsonFormat.Init(map[string]string{})
+	if assert.NoError(t, err) {
+		observations, err := tweetJsonFormat.GetObservations([]byte(`[{"created_at":"Thu Sep 30 12:36:31 +0000 2021","text":"#BTC","entities":{"hashtags":[{"text":"BTC"}]}}]`))
+		if assert.NoError(t, err) && assert.Len(t, observations, 1) {
+			assert.Empty(t, observations[0].Tags)
+		}
+	}
+
+	err = tweetJsonFormat.Init(map[string]string{"fields": "favorite_count,sentiment"})
+	assert.Error(t, err)
+
+	err = tweetJsonFormat.Init(map[string]string{"tags": "urls"})
+	assert.Error(t, err)
+}
+
+func TestTweetJsonState(t *testing.T) {
+	tweets := []*twitter.Tweet{
+		{
+			CreatedAt: "Thu Sep 30 12:36:31 +0000 2021",
+			Lang:      "en",
+			Text:      "Bitcoin #BTC #crypto",
+			Entities:  &twitter.Entities{Hashtags: []twitter.HashtagEntity{{Text: "BTC"}, {Text: "crypto"}}},
+		},
+		{
+			CreatedAt: "Thu Sep 30 12:37:00 +0000 2021",
+			Lang:      "es",
+			Text:      "Ethereum #crypto",
+			Entities:  &twitter.Entities{Hashtags: []twitter.HashtagEntity{{Text: "crypto"}}},
+		},
+		{
+			CreatedAt: "Thu Sep 30 12:38:00 +0000 2021",
+			Lang:      "en",
+			Text:      "No hashtags",
+		},
+	}
+
+	data, err := json.Marshal(tweets)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	getState := func(t *testing.T, params map[string]string, validFields []string) ([]*state.State, error) {
+		tweetJsonFormat := &tweet.TweetJsonFormat{}
+		err := tweetJsonFormat.Init(params)
+		if err != nil {
+			return nil, err
+		}
+		return tweetJsonFormat.GetState(data, validFields)
+	}
+
+	t.Run("GetState() default path", func(t *testing.T) {
+		states, err := getState(t, map[string]string{"fields": "favorite_count,text_length", "tags": "lang"}, nil)
+		if assert.NoError(t, err) && assert.Len(t, states, 1) {
+			assert.Equal(t, "twitter.tweets", states[0].Path())
+			assert.Equal(t, []string{"twitter.tweets.favorite_count", "twitter.tweets.text_length"}, states[0].Fields())
+			assert.Equal(t, []string{"en", "es"}, states[0].Tags())
+			assert.Len(t, states[0].Observations(), 3)
+		}
+	})
+
+	t.Run("GetState() by language", func(t *testing.T) {
+		states, err := getState(t, map[string]string{"state_path": "twitter.<lang>"}, nil)
+		if assert.NoError(t, err) && assert.Len(t, states, 2) {
+			assert.Equal(t, "twitter.en", states[0].Path())
+			assert.Len(t, states[0].Observations(), 2)
+			assert.Equal(t, "twitter.es", states[1].Path())
+			assert.Len(t, states[1].Observations(), 1)
+			assert.Equal(t, []string{"es"}, states[1].Tags())
+		}
+	})
+
+	t.Run("GetState() by hashtag", func(t *testing.T) {
+		states, err := getState(t, map[string]string{"state_path": "twitter.hashtags.<hashtag>"}, nil)
+		if assert.NoError(t, err) && assert.Len(t, states, 2) {
+			assert.Equal(t, "twitter.hashtags.btc", states[0].Path())
+			assert.Len(t, states[0].Observations(), 1)
+			assert.Equal(t, "twitter.hashtags.crypto", states[1].Path())
+			assert.Len(t, states[1].Observations(), 2)
+		}
+	})
+
+	t.Run("GetState() with valid fields", func(t *testing.T) {
+		params := map[string]string{"state_path": "twitter.<lang>", "fields": "favorite_count"}
+
+		_, err := getState(t, params, []string{"twitter.en.favorite_count", "twitter.es.favorite_count"})
+		assert.NoError(t, err)
+
+		// Languages the pod doesn't declare are skipped
+		states, err := getState(t, params, []string{"twitter.en.favorite_count"})
+		if assert.NoError(t, err) && assert.Len(t, states, 1) {
+			assert.Equal(t, "twitter.en", states[0].Path())
+		}
+
+		// A declared path must have all the selected fields
+		_, err = getState(t, map[string]string{"state_path": "twitter.<lang>", "fields": "favorite_count,text_length"}, []string{"twitter.en.favorite_count", "twitter.en.text_length", "twitter.es.favorite_count"})
+		assert.EqualError(t, err, "unknown field 'twitter.es.text_length'")
+
+		// A fixed path must be declared
+		_, err = getState(t, map[string]string{"fields": "favorite_count"}, []string{"twitter.en.favorite_count"})
+		assert.EqualError(t, err, "unknown field 'twitter.tweets.favorite_count'")
+	})
+
+	t.Run("Init() with invalid state_path", func(t *testing.T) {
+		for _, statePath := range []string{"", "twitter.", "twitter..tweets", "twitter.<user>"} {
+			_, err := getState(t, map[string]string{"state_path": statePath}, nil)
+			assert.Error(t, err, statePath)
+		}
+	})
+}
+
+func TestTweetJsonSentiment(t *testing.T) {
+	tweets := []*twitter.Tweet{
+		{
+			CreatedAt: "Thu Sep 30 12:36:31 +0000 2021",
+			Text:      "Great rally, #bitcoin is going to the moon! @scam https://t.co/scam",
+		},
+		{
+			CreatedAt: "Thu Sep 30 12:37:00 +0000 2021",
+			Text:      "Not good. This looks like a rug pull, don't panic",
+		},
+		{
+			CreatedAt: "Thu Sep 30 12:38:00 +0000 2021",
+			Text:      "Nothing to see here",
+		},
+	}
+
+	data, err := json.Marshal(tweets)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	getSentiment := func(t *testing.T, params map[string]string) []map[string]float64 {
+		params["fields"] = "sentiment_score,positive_terms,negative_terms"
+
+		tweetJsonFormat := &tweet.TweetJsonFormat{}
+		err := tweetJsonFormat.Init(params)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		observations, err := tweetJsonFormat.GetObservations(data)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		var sentiment []map[string]float64
+		for _, observation := range observations {
+			sentiment = append(sentiment, observation.Data)
+		}
+		return sentiment
+	}
+
+	t.Run("GetObservations() with the bundled lexicon", func(t *testing.T) {
+		// "great" 3, "rally" 2 and "to the moon" 3, while the mention and link are skipped.
+		// "good" 3 and "panic" -3 are negated, "rug pull" -4.
+		assert.Equal(t, []map[string]float64{
+			{"sentiment_score": 8, "positive_terms": 3, "negative_terms": 0},
+			{"sentiment_score": -4, "positive_terms": 1, "negative_terms": 2},
+			{"sentiment_score": 0, "positive_terms": 0, "negative_terms": 0},
+		}, getSentiment(t, map[string]string{}))
+	})
+
+	t.Run("GetObservations() with a sentiment_lexicon file", func(t *testing.T) {
+		lexiconPath := filepath.Join(t.TempDir(), "lexicon.tsv")
+		lexicon := "# Pod specific terms\nrally\t4\nlooks like\t-1\nhere\t1.5\n"
+		err := os.WriteFile(lexiconPath, []byte(lexicon), 0600)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		assert.Equal(t, []map[string]float64{
+			{"sentiment_score": 10, "positive_terms": 3, "negative_terms": 0},
+			{"sentiment_score": -5, "positive_terms": 1, "negative_terms": 3},
+			{"sentiment_score": 1.5, "positive_terms": 1, "negative_terms": 0},
+		}, getSentiment(t, map[string]string{"sentiment_lexicon": lexiconPath}))
+	})
+
+	t.Run("GetObservations() with a sentiment_lexicon relative to the app directory", func(t *testing.T) {
+		appDir := t.TempDir()
+		err := os.WriteFile(filepath.Join(appDir, "lexicon.tsv"), []byte("rally\t4\nlooks like\t-1\nhere\t1.5\n"), 0600)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		assert.Equal(t, []map[string]float64{
+			{"sentiment_score": 10, "positive_terms": 3, "negative_terms": 0},
+			{"sentiment_score": -5, "positive_terms": 1, "negative_terms": 3},
+			{"sentiment_score": 1.5, "positive_terms": 1, "negative_terms": 0},
+		}, getSentiment(t, map[string]string{"sentiment_lexicon": "lexicon.tsv", "appDirectory": appDir}))
+	})
+
+	t.Run("Init() with an invalid sentiment_lexicon", func(t *testing.T) {
+		dir := t.TempDir()
+
+		tweetJsonFormat := &tweet.TweetJsonFormat{}
+		err := tweetJsonFormat.Init(map[string]string{"sentiment_lexicon": filepath.Join(dir, "missing.tsv")})
+		assert.Error(t, err)
+
+		lexiconPath := filepath.Join(dir, "lexicon.tsv")
+		err = os.WriteFile(lexiconPath, []byte("good\t1\nbad -1\n"), 0600)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		err = tweetJsonFormat.Init(map[string]string{"sentiment_lexicon": lexiconPath})
+		if assert.Error(t, err) {
+			assert.Equal(t, "invalid sentiment_lexicon '"+lexiconPath+"': line 2: expected a term and score separated by a tab", err.Error())
+		}
+	})
+}
@@ -0,0 +1,211 @@
+package tweetv2
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spiceai/spiceai/pkg/observations"
+	"github.com/spiceai/spiceai/pkg/state"
+)
+
+var (
+	//go:embed tweet_v2_schema.json
+	jsonSchema []byte
+)
+
+const defaultStatePath string = "twitter.tweets"
+
+// A Twitter API v2 filtered stream payload, as delivered by the twitter connector with api_version 2
+type TweetPayload struct {
+	Data          Tweet          `json:"data"`
+	Includes      Includes       `json:"includes"`
+	MatchingRules []MatchingRule `json:"matching_rules"`
+}
+
+type Tweet struct {
+	ID            string             `json:"id"`
+	Text          string             `json:"text"`
+	CreatedAt     string             `json:"created_at"`
+	AuthorID      string             `json:"author_id"`
+	Lang          string             `json:"lang"`
+	PublicMetrics map[string]float64 `json:"public_metrics"`
+}
+
+type User struct {
+	ID            string             `json:"id"`
+	Username      string             `json:"username"`
+	Name          string             `json:"name"`
+	Verified      bool               `json:"verified"`
+	PublicMetrics map[string]float64 `json:"public_metrics"`
+}
+
+type Includes struct {
+	Users []User `json:"users"`
+}
+
+type MatchingRule struct {
+	ID  string `json:"id"`
+	Tag string `json:"tag"`
+}
+
+type TweetV2JsonFormat struct {
+	statePath string
+}
+
+// Init sets the path state is kept under from 'state_path'
+func (s *TweetV2JsonFormat) Init(params map[string]string) error {
+	s.statePath = defaultStatePath
+	if statePath, ok := params["state_path"]; ok {
+		if statePath == "" || strings.ContainsAny(statePath, "<>") || strings.HasPrefix(statePath, ".") || strings.HasSuffix(statePath, ".") || strings.Contains(statePath, "..") {
+			return fmt.Errorf("invalid state_path '%s': expected a dotted path such as '%s'", statePath, defaultStatePath)
+		}
+		s.statePath = statePath
+	}
+
+	return nil
+}
+
+func (s *TweetV2JsonFormat) GetSchema() []byte {
+	return jsonSchema
+}
+
+func (s *TweetV2JsonFormat) GetObservations(data []byte) ([]observations.Observation, error) {
+	var payloads []TweetPayload
+
+	err := json.Unmarshal(data, &payloads)
+	if err != nil {
+		return nil, err
+	}
+
+	var newObservations []observations.Observation
+	for _, payload := range payloads {
+		observation, err := payloadObservation(&payload)
+		if err != nil {
+			return nil, err
+		}
+		newObservations = append(newObservations, observation)
+	}
+
+	return newObservations, nil
+}
+
+// Processes into State under the path from 'state_path', "twitter.tweets" by default.
+// The fields are the metrics found in the payloads, each of which must be a valid field when validFields is set.
+func (s *TweetV2JsonFormat) GetState(data []byte, validFields []string) ([]*state.State, error) {
+	var payloads []TweetPayload
+
+	err := json.Unmarshal(data, &payloads)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(payloads) == 0 {
+		return nil, nil
+	}
+
+	statePath := s.statePath
+	if statePath == "" {
+		statePath = defaultStatePath
+	}
+
+	var newObservations []observations.Observation
+	fieldSet := make(map[string]bool)
+	tagSet := make(map[string]bool)
+	for _, payload := range payloads {
+		observation, err := payloadObservation(&payload)
+		if err != nil {
+			return nil, err
+		}
+		newObservations = append(newObservations, observation)
+
+		for field := range observation.Data {
+			fieldSet[field] = true
+		}
+		for _, tag := range observation.Tags {
+			tagSet[tag] = true
+		}
+	}
+
+	fields := make([]string, 0, len(fieldSet))
+	for field := range fieldSet {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	if validFields != nil {
+		for _, field := range fields {
+			if !containsString(validFields, statePath+"."+field) {
+				return nil, fmt.Errorf("unknown field '%s.%s'", statePath, field)
+			}
+		}
+	}
+
+	tags := make([]string, 0, len(tagSet))
+	for tag := range tagSet {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	return []*state.State{state.NewState(statePath, fields, tags, newObservations)}, nil
+}
+
+func payloadObservation(payload *TweetPayload) (observations.Observation, error) {
+	tweet := payload.Data
+
+	t, err := time.Parse(time.RFC3339, tweet.CreatedAt)
+	if err != nil {
+		return observations.Observation{}, fmt.Errorf("tweet time format is invalid: %s", tweet.CreatedAt)
+	}
+
+	data := make(map[string]float64)
+	for metric, value := range tweet.PublicMetrics {
+		data[metric] = value
+	}
+
+	// Author metrics come from the author_id expansion
+	for _, user := range payload.Includes.Users {
+		if user.ID != tweet.AuthorID {
+			continue
+		}
+		for metric, value := range user.PublicMetrics {
+			data["author_"+metric] = value
+		}
+		if user.Verified {
+			data["author_verified"] = 1
+		} else {
+			data["author_verified"] = 0
+		}
+		break
+	}
+
+	var tags []string
+	if tweet.Lang != "" {
+		tags = append(tags, tweet.Lang)
+	}
+	for _, rule := range payload.MatchingRules {
+		if rule.Tag != "" {
+			// Tags are space-separated downstream
+			tags = append(tags, strings.Join(strings.Fields(rule.Tag), "_"))
+		}
+	}
+	sort.Strings(tags)
+
+	return observations.Observation{
+		Time: t.Unix(),
+		Data: data,
+		Tags: tags,
+	}, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package tweetv2_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/spiceai/data-components-contrib/dataprocessors/json/tweetv2"
+	"github.com/spiceai/spiceai/pkg/observations"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTweetV2Json(t *testing.T) {
+	data, err := os.ReadFile("../../../test/assets/data/json/tweet_v2_valid.json")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tweetJsonFormat := &tweetv2.TweetV2JsonFormat{}
+
+	observationsResult, err := tweetJsonFormat.GetObservations(data)
+	if assert.NoError(t, err) {
+		expected := []observations.Observation{
+			{
+				Time: 1633561496,
+				Data: map[string]float64{
+					"retweet_count":          12,
+					"reply_count":            3,
+					"like_count":             48,
+					"quote_count":            1,
+					"author_followers_count": 1024,
+					"author_following_count": 12,
+					"author_tweet_count":     4096,
+					"author_listed_count":    8,
+					"author_verified":        1,
+				},
+				Tags: []string{"crypto_news", "en", "track:bitcoin"},
+			},
+			{
+				Time: 1633561510,
+				Data: map[string]float64{},
+				Tags: []string{"es", "follow:783214"},
+			},
+		}
+		assert.Equal(t, expected, observationsResult)
+	}
+
+	_, err = tweetJsonFormat.GetObservations([]byte(`[{"data":{"id":"1","created_at":"yesterday"}}]`))
+	assert.Error(t, err)
+
+	t.Run("GetState()", func(t *testing.T) {
+		tweetJsonFormat := &tweetv2.TweetV2JsonFormat{}
+		err := tweetJsonFormat.Init(map[string]string{"state_path": "twitter.v2"})
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		stateResult, err := tweetJsonFormat.GetState(data, nil)
+		if assert.NoError(t, err) && assert.Len(t, stateResult, 1) {
+			assert.Equal(t, "twitter.v2", stateResult[0].Path())
+			assert.Equal(t, []string{
+				"author_followers_count",
+				"author_following_count",
+				"author_listed_count",
+				"author_tweet_count",
+				"author_verified",
+				"like_count",
+				"quote_count",
+				"reply_count",
+				"retweet_count",
+			}, stateResult[0].FieldNames())
+			assert.Equal(t, []string{"crypto_news", "en", "es", "follow:783214", "track:bitcoin"}, stateResult[0].Tags())
+			assert.Len(t, stateResult[0].Observations(), 2)
+		}
+
+		_, err = tweetJsonFormat.GetState(data, []string{"twitter.v2.like_count"})
+		assert.EqualError(t, err, "unknown field 'twitter.v2.author_followers_count'")
+	})
+
+	t.Run("Init() with invalid state_path", func(t *testing.T) {
+		for _, statePath := range []string{"", "twitter.<lang>", ".twitter", "twitter..tweets"} {
+			err := (&tweetv2.TweetV2JsonFormat{}).Init(map[string]string{"state_path": statePath})
+			assert.Error(t, err, statePath)
+		}
+	})
+}
@@ -0,0 +1,478 @@
+package tweetvolume
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+	"github.com/spiceai/data-components-contrib/dataprocessors/internal/tweettext"
+	"github.com/spiceai/spiceai/pkg/observations"
+	"github.com/spiceai/spiceai/pkg/state"
+	"github.com/spiceai/spiceai/pkg/util"
+)
+
+const (
+	TweetVolumeProcessorName string = "tweet-volume"
+
+	tweetFormat   string = "tweet"
+	tweetV2Format string = "tweet_v2"
+
+	groupByKeyword string = "keyword"
+	groupByLang    string = "lang"
+	groupByHashtag string = "hashtag"
+
+	defaultBucketSize = time.Minute
+	defaultLateness   = 5 * time.Second
+
+	// The search API backfill can't return tweets older than 7 days, so buckets that old can't receive late tweets
+	flushedRetention = 8 * 24 * time.Hour
+)
+
+// Aggregates the twitter connector's tweets into fixed time buckets, emitting one observation per
+// bucket and group once the bucket has closed
+type TweetVolumeProcessor struct {
+	format     string
+	bucketSize time.Duration
+	lateness   time.Duration
+	groupBy    string
+	keywords   []string
+	now        func() time.Time
+
+	dataMutex sync.Mutex
+	dataHash  []byte
+	buckets   map[int64]*bucket
+	flushed   map[int64]bool
+	late      int
+	// Without group_by, the start of the next bucket emitted with zero counts if it closes without tweets
+	emptyFrom time.Time
+}
+
+type bucket struct {
+	start      time.Time
+	lastUpdate time.Time
+	groups     map[string]*totals
+}
+
+// The number of tweets in a group and their summed engagement
+type totals struct {
+	count    float64
+	likes    float64
+	retweets float64
+	replies  float64
+	quotes   float64
+}
+
+// A tweet reduced to what is aggregated, from either format
+type volumeTweet struct {
+	createdAt time.Time
+	text      string
+	lang      string
+	hashtags  []string
+	rules     []string
+	likes     float64
+	retweets  float64
+	replies   float64
+	quotes    float64
+}
+
+type v2Payload struct {
+	Data struct {
+		Text          string             `json:"text"`
+		CreatedAt     string             `json:"created_at"`
+		Lang          string             `json:"lang"`
+		PublicMetrics map[string]float64 `json:"public_metrics"`
+		Entities      struct {
+			Hashtags []struct {
+				Tag string `json:"tag"`
+			} `json:"hashtags"`
+		} `json:"entities"`
+	} `json:"data"`
+	MatchingRules []struct {
+		Tag string `json:"tag"`
+	} `json:"matching_rules"`
+}
+
+func NewTweetVolumeProcessor() *TweetVolumeProcessor {
+	return &TweetVolumeProcessor{
+		format:     tweetFormat,
+		bucketSize: defaultBucketSize,
+		lateness:   defaultLateness,
+		now:        time.Now,
+		buckets:    make(map[int64]*bucket),
+		flushed:    make(map[int64]bool),
+	}
+}
+
+func (p *TweetVolumeProcessor) Init(params map[string]string) error {
+	if format, ok := params["format"]; ok {
+		if format != tweetFormat && format != tweetV2Format {
+			return fmt.Errorf("invalid format '%s': must be one of %s, %s", format, tweetFormat, tweetV2Format)
+		}
+		p.format = format
+	}
+
+	if bucketSize, ok := params["bucket_size"]; ok {
+		bs, err := time.ParseDuration(bucketSize)
+		if err != nil {
+			return fmt.Errorf("invalid bucket_size '%s': %s", bucketSize, err)
+		}
+		if bs < time.Second {
+			return fmt.Errorf("invalid bucket_size '%s': bucket size must be at least 1s", bucketSize)
+		}
+		p.bucketSize = bs
+	}
+
+	if lateness, ok := params["lateness"]; ok {
+		l, err := time.ParseDuration(lateness)
+		if err != nil {
+			return fmt.Errorf("invalid lateness '%s': %s", lateness, err)
+		}
+		if l < 0 {
+			return fmt.Errorf("invalid lateness '%s': lateness must be >= 0", lateness)
+		}
+		p.lateness = l
+	}
+
+	if groupBy, ok := params["group_by"]; ok {
+		switch groupBy {
+		case "", groupByKeyword, groupByLang, groupByHashtag:
+			p.groupBy = groupBy
+		default:
+			return fmt.Errorf("invalid group_by '%s': must be one of %s, %s, %s", groupBy, groupByKeyword, groupByLang, groupByHashtag)
+		}
+	}
+
+	for _, keyword := range strings.Split(params["keywords"], ",") {
+		keyword = strings.Join(tweettext.Tokenize(keyword), " ")
+		if keyword != "" {
+			p.keywords = append(p.keywords, keyword)
+		}
+	}
+
+	// v2 payloads carry the tags of the rules that matched them, v1.1 tweets don't
+	if p.groupBy == groupByKeyword && len(p.keywords) == 0 && p.format != tweetV2Format {
+		return fmt.Errorf("keywords is required to group the %s format by keyword", p.format)
+	}
+
+	// Groups are only known once tweets arrive, so only the ungrouped count can be zero.
+	// Start from the first full bucket, backfilled buckets without tweets are left out.
+	p.emptyFrom = time.Time{}
+	if p.groupBy == "" {
+		now := p.now()
+		p.emptyFrom = now.Truncate(p.bucketSize)
+		if p.emptyFrom.Before(now) {
+			p.emptyFrom = p.emptyFrom.Add(p.bucketSize)
+		}
+	}
+
+	return nil
+}
+
+// Adds the tweets in data to their buckets
+func (p *TweetVolumeProcessor) OnData(data []byte) ([]byte, error) {
+	p.dataMutex.Lock()
+	defer p.dataMutex.Unlock()
+
+	newDataHash, err := util.ComputeNewHash(nil, p.dataHash, data)
+	if err != nil {
+		return nil, fmt.Errorf("error computing new data hash in %s processor: %w", TweetVolumeProcessorName, err)
+	}
+
+	if newDataHash == nil {
+		// Counting the same payload twice would inflate the buckets
+		return data, nil
+	}
+
+	tweets, err := p.parseTweets(data)
+	if err != nil {
+		return nil, err
+	}
+
+	p.dataHash = newDataHash
+
+	now := p.now()
+	for _, tweet := range tweets {
+		p.add(tweet, now)
+	}
+
+	return data, nil
+}
+
+// Returns the observations of the buckets that have closed. A bucket closes once lateness has passed
+// since both its end and the last tweet added to it, so a backfill filling in old buckets completes them first.
+func (p *TweetVolumeProcessor) GetObservations() ([]observations.Observation, error) {
+	p.dataMutex.Lock()
+	defer p.dataMutex.Unlock()
+
+	now := p.now()
+
+	if p.late > 0 {
+		log.Printf("%s processor dropped %d tweets for buckets already emitted", TweetVolumeProcessorName, p.late)
+		p.late = 0
+	}
+
+	var closed []*bucket
+	for start, b := range p.buckets {
+		end := b.start.Add(p.bucketSize)
+		if now.Before(end.Add(p.lateness)) || now.Before(b.lastUpdate.Add(p.lateness)) {
+			continue
+		}
+		closed = append(closed, b)
+		delete(p.buckets, start)
+		p.flushed[start] = true
+	}
+
+	if !p.emptyFrom.IsZero() {
+		for ; !now.Before(p.emptyFrom.Add(p.bucketSize).Add(p.lateness)); p.emptyFrom = p.emptyFrom.Add(p.bucketSize) {
+			start := p.emptyFrom.Unix()
+			if p.flushed[start] || p.buckets[start] != nil {
+				continue
+			}
+			closed = append(closed, &bucket{start: p.emptyFrom, groups: map[string]*totals{"": {}}})
+			p.flushed[start] = true
+		}
+	}
+
+	for start := range p.flushed {
+		if now.Sub(time.Unix(start, 0)) > flushedRetention {
+			delete(p.flushed, start)
+		}
+	}
+
+	sort.Slice(closed, func(i, j int) bool {
+		return closed[i].start.Before(closed[j].start)
+	})
+
+	var newObservations []observations.Observation
+	for _, b := range closed {
+		groups := make([]string, 0, len(b.groups))
+		for group := range b.groups {
+			groups = append(groups, group)
+		}
+		sort.Strings(groups)
+
+		for _, group := range groups {
+			t := b.groups[group]
+
+			var tags []string
+			if group != "" {
+				tags = []string{group}
+			}
+
+			newObservations = append(newObservations, observations.Observation{
+				Time: b.start.Unix(),
+				Data: map[string]float64{
+					"count":             t.count,
+					"like_count_sum":    t.likes,
+					"retweet_count_sum": t.retweets,
+					"reply_count_sum":   t.replies,
+					"quote_count_sum":   t.quotes,
+				},
+				Tags: tags,
+			})
+		}
+	}
+
+	return newObservations, nil
+}
+
+// Buckets are only complete once they close, so they are emitted as observations and never as state
+func (p *TweetVolumeProcessor) GetState(validFields []string) ([]*state.State, error) {
+	return nil, fmt.Errorf("%s processor does not support state, use its observations instead", TweetVolumeProcessorName)
+}
+
+func (p *TweetVolumeProcessor) add(tweet *volumeTweet, now time.Time) {
+	start := tweet.createdAt.Truncate(p.bucketSize)
+	key := start.Unix()
+
+	if p.flushed[key] {
+		p.late++
+		return
+	}
+
+	groups := p.getGroups(tweet)
+	if len(groups) == 0 {
+		return
+	}
+
+	b, ok := p.buckets[key]
+	if !ok {
+		b = &bucket{start: start, groups: make(map[string]*totals)}
+		p.buckets[key] = b
+	}
+	b.lastUpdate = now
+
+	for _, group := range groups {
+		t, ok := b.groups[group]
+		if !ok {
+			t = &totals{}
+			b.groups[group] = t
+		}
+		t.count++
+		t.likes += tweet.likes
+		t.retweets += tweet.retweets
+		t.replies += tweet.replies
+		t.quotes += tweet.quotes
+	}
+}
+
+// Returns the groups a tweet is counted in, used as the observation tags.
+// Without group_by every tweet is counted in a single untagged group.
+func (p *TweetVolumeProcessor) getGroups(tweet *volumeTweet) []string {
+	var groups []string
+	seen := make(map[string]bool)
+	addGroup := func(group string) {
+		// Tags are space-separated downstream
+		group = strings.Join(strings.Fields(strings.ToLower(group)), "_")
+		if group != "" && !seen[group] {
+			seen[group] = true
+			groups = append(groups, group)
+		}
+	}
+
+	switch p.groupBy {
+	case "":
+		return []string{""}
+	case groupByLang:
+		if tweet.lang == "" {
+			// Twitter's code for an undetermined language
+			return []string{"und"}
+		}
+		addGroup(tweet.lang)
+	case groupByHashtag:
+		for _, hashtag := range tweet.hashtags {
+			addGroup("#" + hashtag)
+		}
+	case groupByKeyword:
+		if len(p.keywords) == 0 {
+			for _, rule := range tweet.rules {
+				addGroup(rule)
+			}
+			break
+		}
+		words := make(map[string]bool)
+		for _, word := range tweettext.Tokenize(tweet.text) {
+			words[word] = true
+		}
+		for _, keyword := range p.keywords {
+			if matchesKeyword(words, keyword) {
+				addGroup(keyword)
+			}
+		}
+	}
+
+	return groups
+}
+
+// Matches like the stream's track param, a keyword matches when the text has all of its words.
+// Words are split the same way the tweet format scores sentiment, so "eth" doesn't match "whether".
+func matchesKeyword(words map[string]bool, keyword string) bool {
+	for _, word := range strings.Fields(keyword) {
+		if !words[word] {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *TweetVolumeProcessor) parseTweets(data []byte) ([]*volumeTweet, error) {
+	if p.format == tweetV2Format {
+		return parseV2Tweets(data)
+	}
+	return parseV1Tweets(data)
+}
+
+func parseV1Tweets(data []byte) ([]*volumeTweet, error) {
+	var tweets []twitter.Tweet
+	err := json.Unmarshal(data, &tweets)
+	if err != nil {
+		return nil, err
+	}
+
+	var volumeTweets []*volumeTweet
+	for i := range tweets {
+		tweet := &tweets[i]
+
+		createdAt, err := tweet.CreatedAtTime()
+		if err != nil {
+			return nil, fmt.Errorf("tweet time format is invalid: %s", tweet.CreatedAt)
+		}
+
+		text := tweet.FullText
+		if text == "" {
+			text = tweet.Text
+		}
+		entities := tweet.Entities
+		if tweet.ExtendedTweet != nil {
+			text = tweet.ExtendedTweet.FullText
+			entities = tweet.ExtendedTweet.Entities
+		}
+
+		var hashtags []string
+		if entities != nil {
+			for _, hashtag := range entities.Hashtags {
+				hashtags = append(hashtags, hashtag.Text)
+			}
+		}
+
+		volumeTweets = append(volumeTweets, &volumeTweet{
+			createdAt: createdAt,
+			text:      text,
+			lang:      tweet.Lang,
+			hashtags:  hashtags,
+			likes:     float64(tweet.FavoriteCount),
+			retweets:  float64(tweet.RetweetCount),
+			replies:   float64(tweet.ReplyCount),
+			quotes:    float64(tweet.QuoteCount),
+		})
+	}
+
+	return volumeTweets, nil
+}
+
+func parseV2Tweets(data []byte) ([]*volumeTweet, error) {
+	var payloads []v2Payload
+	err := json.Unmarshal(data, &payloads)
+	if err != nil {
+		return nil, err
+	}
+
+	var volumeTweets []*volumeTweet
+	for _, payload := range payloads {
+		tweet := payload.Data
+
+		createdAt, err := time.Parse(time.RFC3339, tweet.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("tweet time format is invalid: %s", tweet.CreatedAt)
+		}
+
+		var hashtags []string
+		for _, hashtag := range tweet.Entities.Hashtags {
+			hashtags = append(hashtags, hashtag.Tag)
+		}
+
+		var rules []string
+		for _, rule := range payload.MatchingRules {
+			rules = append(rules, rule.Tag)
+		}
+
+		volumeTweets = append(volumeTweets, &volumeTweet{
+			createdAt: createdAt,
+			text:      tweet.Text,
+			lang:      tweet.Lang,
+			hashtags:  hashtags,
+			rules:     rules,
+			likes:     tweet.PublicMetrics["like_count"],
+			retweets:  tweet.PublicMetrics["retweet_count"],
+			replies:   tweet.PublicMetrics["reply_count"],
+			quotes:    tweet.PublicMetrics["quote_count"],
+		})
+	}
+
+	return volumeTweets, nil
+}
@@ -0,0 +1,407 @@
+package tweetvolume
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spiceai/spiceai/pkg/observations"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTweetVolume(t *testing.T) {
+	t.Run("Init()", testInitFunc())
+	t.Run("Init() -- invalid params", testInitInvalidFunc())
+	t.Run("GetObservations() -- buckets close after lateness", testBucketCloseFunc())
+	t.Run("GetObservations() -- group_by keyword", testGroupByKeywordFunc())
+	t.Run("GetObservations() -- group_by lang and hashtag", testGroupByLangHashtagFunc())
+	t.Run("GetObservations() -- late tweets are dropped", testLateTweetsFunc())
+	t.Run("GetObservations() -- same data", testSameDataFunc())
+	t.Run("GetObservations() -- tweet_v2 format", testTweetV2Func())
+	t.Run("GetObservations() -- empty buckets", testEmptyBucketsFunc())
+	t.Run("GetState()", testGetStateFunc())
+}
+
+// A processor with a clock the test controls
+func newTestProcessor(t *testing.T, params map[string]string, now *time.Time) *TweetVolumeProcessor {
+	p := NewTweetVolumeProcessor()
+	p.now = func() time.Time { return *now }
+
+	err := p.Init(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func v1Tweet(createdAt time.Time, text string, lang string, hashtags []string, favorites int) string {
+	var entities []string
+	for _, hashtag := range hashtags {
+		entities = append(entities, fmt.Sprintf(`{"text":%q}`, hashtag))
+	}
+	return fmt.Sprintf(`{"created_at":%q,"text":%q,"lang":%q,"entities":{"hashtags":[%s]},"favorite_count":%d,"retweet_count":1,"reply_count":0,"quote_count":0}`,
+		createdAt.Format(time.RubyDate), text, lang, strings.Join(entities, ","), favorites)
+}
+
+func tweetData(tweets ...string) []byte {
+	return []byte("[" + strings.Join(tweets, ",") + "]")
+}
+
+// Tests "Init()"
+func testInitFunc() func(*testing.T) {
+	return func(t *testing.T) {
+		p := NewTweetVolumeProcessor()
+		err := p.Init(map[string]string{
+			"format":      "tweet_v2",
+			"bucket_size": "5m",
+			"lateness":    "30s",
+			"group_by":    "keyword",
+			"keywords":    "Bitcoin, spice  AI,",
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "tweet_v2", p.format)
+		assert.Equal(t, 5*time.Minute, p.bucketSize)
+		assert.Equal(t, 30*time.Second, p.lateness)
+		assert.Equal(t, "keyword", p.groupBy)
+		assert.Equal(t, []string{"bitcoin", "spice ai"}, p.keywords)
+
+		p = NewTweetVolumeProcessor()
+		err = p.Init(map[string]string{})
+		assert.NoError(t, err)
+		assert.Equal(t, "tweet", p.format)
+		assert.Equal(t, time.Minute, p.bucketSize)
+		assert.Equal(t, 5*time.Second, p.lateness)
+		assert.Equal(t, "", p.groupBy)
+	}
+}
+
+// Tests "Init()" with invalid params
+func testInitInvalidFunc() func(*testing.T) {
+	return func(t *testing.T) {
+		testCases := []struct {
+			params map[string]string
+			err    string
+		}{
+			{map[string]string{"format": "csv"}, "invalid format 'csv': must be one of tweet, tweet_v2"},
+			{map[string]string{"bucket_size": "soon"}, "invalid bucket_size 'soon': time: invalid duration \"soon\""},
+			{map[string]string{"bucket_size": "500ms"}, "invalid bucket_size '500ms': bucket size must be at least 1s"},
+			{map[string]string{"lateness": "-1s"}, "invalid lateness '-1s': lateness must be >= 0"},
+			{map[string]string{"group_by": "user"}, "invalid group_by 'user': must be one of keyword, lang, hashtag"},
+			{map[string]string{"group_by": "keyword"}, "keywords is required to group the tweet format by keyword"},
+		}
+
+		for _, tc := range testCases {
+			p := NewTweetVolumeProcessor()
+			err := p.Init(tc.params)
+			assert.EqualError(t, err, tc.err)
+		}
+	}
+}
+
+// Tests buckets are only emitted once lateness has passed since their end and their last tweet
+func testBucketCloseFunc() func(*testing.T) {
+	return func(t *testing.T) {
+		bucketStart := time.Date(2021, 10, 6, 23, 4, 0, 0, time.UTC)
+		now := bucketStart.Add(30 * time.Second)
+		p := newTestProcessor(t, map[string]string{}, &now)
+
+		_, err := p.OnData(tweetData(
+			v1Tweet(bucketStart.Add(10*time.Second), "first", "en", nil, 2),
+			v1Tweet(bucketStart.Add(20*time.Second), "second", "en", nil, 3),
+			v1Tweet(bucketStart.Add(70*time.Second), "next bucket", "en", nil, 4),
+		))
+		assert.NoError(t, err)
+
+		actualObservations, err := p.GetObservations()
+		assert.NoError(t, err)
+		assert.Empty(t, actualObservations, "bucket is still open")
+
+		now = bucketStart.Add(time.Minute + 4*time.Second)
+		actualObservations, err = p.GetObservations()
+		assert.NoError(t, err)
+		assert.Empty(t, actualObservations, "bucket closes after lateness")
+
+		now = bucketStart.Add(time.Minute + 5*time.Second)
+		actualObservations, err = p.GetObservations()
+		assert.NoError(t, err)
+
+		expectedObservations := []observations.Observation{
+			{
+				Time: bucketStart.Unix(),
+				Data: map[string]float64{
+					"count":             2,
+					"like_count_sum":    5,
+					"retweet_count_sum": 2,
+					"reply_count_sum":   0,
+					"quote_count_sum":   0,
+				},
+			},
+		}
+		assert.Equal(t, expectedObservations, actualObservations)
+
+		actualObservations, err = p.GetObservations()
+		assert.NoError(t, err)
+		assert.Empty(t, actualObservations, "bucket is emitted once")
+
+		now = bucketStart.Add(2*time.Minute + 5*time.Second)
+		actualObservations, err = p.GetObservations()
+		assert.NoError(t, err)
+		if assert.Len(t, actualObservations, 1) {
+			assert.Equal(t, bucketStart.Add(time.Minute).Unix(), actualObservations[0].Time)
+			assert.Equal(t, float64(1), actualObservations[0].Data["count"])
+		}
+	}
+}
+
+// Tests tweets are counted once per keyword they match, and not at all if they match none
+func testGroupByKeywordFunc() func(*testing.T) {
+	return func(t *testing.T) {
+		bucketStart := time.Date(2021, 10, 6, 23, 0, 0, 0, time.UTC)
+		now := bucketStart
+		p := newTestProcessor(t, map[string]string{
+			"bucket_size": "1h",
+			"group_by":    "keyword",
+			"keywords":    "bitcoin,spice ai,eth",
+		}, &now)
+
+		_, err := p.OnData(tweetData(
+			v1Tweet(bucketStart, "Bitcoin is up", "en", nil, 1),
+			v1Tweet(bucketStart, "AI with Spice, and bitcoin", "en", nil, 2),
+			v1Tweet(bucketStart, "spice only", "en", nil, 4),
+			// Keywords only match whole words
+			v1Tweet(bucketStart, "Something tells me bitcoins will do better, whether or not it's spiced up", "en", nil, 8),
+			v1Tweet(bucketStart, "#ETH is up", "en", nil, 16),
+		))
+		assert.NoError(t, err)
+
+		now = bucketStart.Add(2 * time.Hour)
+		actualObservations, err := p.GetObservations()
+		assert.NoError(t, err)
+
+		assert.Equal(t, []string{"bitcoin", "eth", "spice_ai"}, observationTags(actualObservations))
+		assert.Equal(t, []float64{2, 1, 1}, observationField(actualObservations, "count"))
+		assert.Equal(t, []float64{3, 16, 2}, observationField(actualObservations, "like_count_sum"))
+	}
+}
+
+// Tests grouping by language, with undetermined languages as "und", and by lower case hashtag
+func testGroupByLangHashtagFunc() func(*testing.T) {
+	return func(t *testing.T) {
+		bucketStart := time.Date(2021, 10, 6, 23, 0, 0, 0, time.UTC)
+		data := tweetData(
+			v1Tweet(bucketStart, "one", "en", []string{"Bitcoin", "bitcoin", "BTC"}, 1),
+			v1Tweet(bucketStart, "two", "es", []string{"btc"}, 1),
+			v1Tweet(bucketStart, "three", "", nil, 1),
+		)
+
+		now := bucketStart
+		p := newTestProcessor(t, map[string]string{"group_by": "lang"}, &now)
+		_, err := p.OnData(data)
+		assert.NoError(t, err)
+
+		now = bucketStart.Add(time.Hour)
+		actualObservations, err := p.GetObservations()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"en", "es", "und"}, observationTags(actualObservations))
+		assert.Equal(t, []float64{1, 1, 1}, observationField(actualObservations, "count"))
+
+		now = bucketStart
+		p = newTestProcessor(t, map[string]string{"group_by": "hashtag"}, &now)
+		_, err = p.OnData(data)
+		assert.NoError(t, err)
+
+		now = bucketStart.Add(time.Hour)
+		actualObservations, err = p.GetObservations()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"#bitcoin", "#btc"}, observationTags(actualObservations))
+		assert.Equal(t, []float64{1, 2}, observationField(actualObservations, "count"))
+	}
+}
+
+// Tests a bucket receiving tweets stays open, and tweets for an emitted bucket are dropped
+func testLateTweetsFunc() func(*testing.T) {
+	return func(t *testing.T) {
+		bucketStart := time.Date(2021, 10, 6, 23, 0, 0, 0, time.UTC)
+		now := bucketStart.Add(time.Hour)
+		p := newTestProcessor(t, map[string]string{"bucket_size": "1h"}, &now)
+
+		// A backfill delivering an old bucket in several payloads
+		_, err := p.OnData(tweetData(v1Tweet(bucketStart, "one", "en", nil, 0)))
+		assert.NoError(t, err)
+		actualObservations, err := p.GetObservations()
+		assert.NoError(t, err)
+		assert.Empty(t, actualObservations, "bucket received a tweet within lateness")
+
+		now = now.Add(2 * time.Second)
+		_, err = p.OnData(tweetData(v1Tweet(bucketStart.Add(time.Second), "two", "en", nil, 0)))
+		assert.NoError(t, err)
+
+		now = now.Add(5 * time.Second)
+		actualObservations, err = p.GetObservations()
+		assert.NoError(t, err)
+		assert.Equal(t, []float64{2}, observationField(actualObservations, "count"))
+
+		_, err = p.OnData(tweetData(v1Tweet(bucketStart.Add(2*time.Second), "three", "en", nil, 0)))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, p.late)
+
+		// Only the following, empty, bucket
+		now = now.Add(time.Hour)
+		actualObservations, err = p.GetObservations()
+		assert.NoError(t, err)
+		assert.Equal(t, []float64{0}, observationField(actualObservations, "count"))
+		assert.Equal(t, 0, p.late)
+	}
+}
+
+// Tests the same payload twice in a row is only counted once
+func testSameDataFunc() func(*testing.T) {
+	return func(t *testing.T) {
+		bucketStart := time.Date(2021, 10, 6, 23, 0, 0, 0, time.UTC)
+		now := bucketStart
+		p := newTestProcessor(t, map[string]string{"bucket_size": "1h"}, &now)
+
+		data := tweetData(v1Tweet(bucketStart, "one", "en", nil, 0))
+		for i := 0; i < 2; i++ {
+			_, err := p.OnData(data)
+			assert.NoError(t, err)
+		}
+
+		now = bucketStart.Add(time.Hour + 5*time.Second)
+		actualObservations, err := p.GetObservations()
+		assert.NoError(t, err)
+		assert.Equal(t, []float64{1}, observationField(actualObservations, "count"))
+	}
+}
+
+// Tests v2 payloads are grouped by the tags of their matching rules when no keywords are given
+func testTweetV2Func() func(*testing.T) {
+	return func(t *testing.T) {
+		data, err := os.ReadFile("../../test/assets/data/json/tweet_v2_valid.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		now := time.Date(2021, 10, 6, 23, 5, 0, 0, time.UTC)
+		p := newTestProcessor(t, map[string]string{
+			"format":   "tweet_v2",
+			"group_by": "keyword",
+		}, &now)
+
+		_, err = p.OnData(data)
+		assert.NoError(t, err)
+
+		now = now.Add(time.Hour)
+		actualObservations, err := p.GetObservations()
+		assert.NoError(t, err)
+
+		expectedObservations := []observations.Observation{
+			{
+				Time: time.Date(2021, 10, 6, 23, 4, 0, 0, time.UTC).Unix(),
+				Data: map[string]float64{
+					"count":             1,
+					"like_count_sum":    48,
+					"retweet_count_sum": 12,
+					"reply_count_sum":   3,
+					"quote_count_sum":   1,
+				},
+				Tags: []string{"crypto_news"},
+			},
+			{
+				Time: time.Date(2021, 10, 6, 23, 4, 0, 0, time.UTC).Unix(),
+				Data: map[string]float64{
+					"count":             1,
+					"like_count_sum":    48,
+					"retweet_count_sum": 12,
+					"reply_count_sum":   3,
+					"quote_count_sum":   1,
+				},
+				Tags: []string{"track:bitcoin"},
+			},
+			{
+				Time: time.Date(2021, 10, 6, 23, 5, 0, 0, time.UTC).Unix(),
+				Data: map[string]float64{
+					"count":             1,
+					"like_count_sum":    0,
+					"retweet_count_sum": 0,
+					"reply_count_sum":   0,
+					"quote_count_sum":   0,
+				},
+				Tags: []string{"follow:783214"},
+			},
+		}
+		assert.Equal(t, expectedObservations, actualObservations)
+	}
+}
+
+func observationTags(o []observations.Observation) []string {
+	var tags []string
+	for _, observation := range o {
+		tags = append(tags, observation.Tags...)
+	}
+	return tags
+}
+
+func observationField(o []observations.Observation, field string) []float64 {
+	var values []float64
+	for _, observation := range o {
+		values = append(values, observation.Data[field])
+	}
+	return values
+}
+
+// Tests buckets without tweets are emitted with zero counts from the first full bucket, unless grouped
+func testEmptyBucketsFunc() func(*testing.T) {
+	return func(t *testing.T) {
+		bucketStart := time.Date(2021, 10, 6, 23, 0, 0, 0, time.UTC)
+		data := tweetData(
+			v1Tweet(bucketStart.Add(40*time.Second), "one", "en", nil, 1),
+			v1Tweet(bucketStart.Add(2*time.Minute+10*time.Second), "two", "en", nil, 1),
+		)
+
+		now := bucketStart.Add(30 * time.Second)
+		p := newTestProcessor(t, map[string]string{}, &now)
+		_, err := p.OnData(data)
+		assert.NoError(t, err)
+
+		now = bucketStart.Add(4*time.Minute + 5*time.Second)
+		actualObservations, err := p.GetObservations()
+		assert.NoError(t, err)
+		assert.Equal(t, []float64{1, 0, 1, 0}, observationField(actualObservations, "count"))
+		assert.Equal(t, []float64{1, 0, 1, 0}, observationField(actualObservations, "like_count_sum"))
+		for i, observation := range actualObservations {
+			assert.Equal(t, bucketStart.Add(time.Duration(i)*time.Minute).Unix(), observation.Time)
+			assert.Empty(t, observation.Tags)
+		}
+
+		actualObservations, err = p.GetObservations()
+		assert.NoError(t, err)
+		assert.Empty(t, actualObservations, "empty buckets are emitted once")
+
+		now = bucketStart.Add(30 * time.Second)
+		p = newTestProcessor(t, map[string]string{"group_by": "lang"}, &now)
+		_, err = p.OnData(data)
+		assert.NoError(t, err)
+
+		now = bucketStart.Add(4*time.Minute + 5*time.Second)
+		actualObservations, err = p.GetObservations()
+		assert.NoError(t, err)
+		assert.Equal(t, []float64{1, 1}, observationField(actualObservations, "count"))
+	}
+}
+
+// Tests "GetState()" is rejected, buckets are only emitted as observations
+func testGetStateFunc() func(*testing.T) {
+	return func(t *testing.T) {
+		p := NewTweetVolumeProcessor()
+		err := p.Init(map[string]string{})
+		assert.NoError(t, err)
+
+		actualState, err := p.GetState(nil)
+		assert.EqualError(t, err, "tweet-volume processor does not support state, use its observations instead")
+		assert.Nil(t, actualState)
+	}
+}
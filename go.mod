@@ -9,12 +9,14 @@ require (
 	github.com/dghubble/oauth1 v0.7.0
 	github.com/fsnotify/fsnotify v1.5.1
 	github.com/influxdata/flux v0.131.0
-	github.com/influxdata/influxdb-client-go v1.4.0
+	github.com/influxdata/influxdb-client-go/v2 v2.3.1-0.20210518120617-5d1fff431040
 	github.com/influxdata/line-protocol v0.0.0-20210311194329-9aa0e372d097 // indirect
 	github.com/jonboulle/clockwork v0.2.2
+	github.com/klauspost/compress v1.13.4
 	github.com/logrusorgru/aurora v2.0.3+incompatible
 	github.com/spiceai/spiceai v0.2.0-alpha-rc-spiced.0.20210928064733-8a93c58a76a3
 	github.com/stretchr/testify v1.7.0
+	github.com/ulikunitz/xz v0.5.10
 	go.skia.org/infra v0.0.0-20210922034012-a5235f7a8e5b
 	go.uber.org/atomic v1.9.0 // indirect
 	go.uber.org/multierr v1.7.0 // indirect